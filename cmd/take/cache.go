@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deblasis/take/internal/config"
+	"github.com/deblasis/take/pkg/take"
+)
+
+// runCache runs "take cache ls" and "take cache prune" and returns the exit
+// status
+func runCache(command string, args []string) int {
+	flags := flag.NewFlagSet("take cache "+command, flag.ExitOnError)
+	var maxSize byteSize
+	var olderThan time.Duration
+	var all bool
+	if command == "prune" {
+		flags.BoolVar(&all, "all", false, "Remove everything from the cache")
+		flags.Var(&maxSize, "max-size", "Keep the most recently used files up to this size, e.g. 500M or 2G")
+		flags.DurationVar(&olderThan, "older-than", 0, "Remove the files not used for this long, e.g. 720h")
+	}
+	flags.Parse(args)
+	// Emptying the cache must be asked for, and conflicts with the limits
+	limited := maxSize > 0 || olderThan > 0
+	if flags.NArg() != 0 || (command == "prune" && all == limited) {
+		fmt.Fprintln(os.Stderr, "Usage: take cache ls")
+		fmt.Fprintln(os.Stderr, "       take cache prune [-max-size SIZE] [-older-than DURATION]")
+		fmt.Fprintln(os.Stderr, "       take cache prune -all")
+		return 1
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cache, err := take.OpenCache(take.Options{CacheDir: cfg.CacheDir})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if command == "ls" {
+		entries, err := cache.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		listCache(os.Stdout, entries)
+		return 0
+	}
+
+	removed, freed, err := cache.Prune(take.PruneOptions{All: all, MaxSize: int64(maxSize), OlderThan: olderThan})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Removed %d cached downloads, freeing %s\n", len(removed), take.FormatBytes(freed))
+	return 0
+}
+
+// listCache prints the cached and interrupted downloads, the most recently
+// used first, and the space they take
+func listCache(w io.Writer, entries []take.CacheEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tLAST USED\tURL")
+	var total int64
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		url := entry.URL
+		if entry.Partial {
+			url = strings.TrimSpace(url + " (partial)")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", take.FormatBytes(entry.Size), entry.Used.Local().Format("2006-01-02 15:04"), url)
+		if entry.Partial {
+			total += entry.Size
+			continue
+		}
+		// Files downloaded from several URLs are stored once
+		if !seen[entry.SHA256] {
+			seen[entry.SHA256] = true
+			total += entry.Size
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Total: %d cached downloads, %s\n", len(entries), take.FormatBytes(total))
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/deblasis/take/internal/archive"
+	"github.com/deblasis/take/internal/config"
 	"github.com/deblasis/take/internal/git"
+	"github.com/deblasis/take/internal/httpclient"
+	"github.com/deblasis/take/internal/retry"
 	"github.com/deblasis/take/pkg/take"
 )
 
@@ -17,9 +28,45 @@ var (
 )
 
 func main() {
+	// "take cache ls" and "take cache prune" manage the download cache;
+	// "take cache" alone still creates a directory
+	if len(os.Args) > 2 && os.Args[1] == "cache" && (os.Args[2] == "ls" || os.Args[2] == "prune") {
+		os.Exit(runCache(os.Args[2], os.Args[3:]))
+	}
+
 	// Parse flags
 	depth := flag.Int("depth", 0, "Git clone depth (0 for full clone)")
-	force := flag.Bool("force", false, "Force operation even if directory exists")
+	force := flag.Bool("force", false, "Replace an existing clone or extracted directory")
+	root := flag.String("root", "", "Clone repositories into <root>/<host>/<owner>/<repo> (default $TAKE_ROOT or clone_root from the config file)")
+	protocol := flag.String("protocol", "", "Protocol forge shorthands expand to: https or ssh (default from the config file, else https)")
+	ref := flag.String("ref", "", "Branch, tag or commit to check out (also url#ref)")
+	flag.StringVar(ref, "branch", "", "Alias for -ref")
+	filter := flag.String("filter", "", "Partial clone filter, e.g. blob:none or tree:0")
+	sparse := flag.String("sparse", "", "Comma-separated directories to check out with a sparse checkout")
+	recursive := flag.Bool("recursive", false, "Initialize git submodules recursively (shallow with -depth)")
+	template := flag.Bool("template", false, "Copy a repository without its history")
+	initRepo := flag.Bool("init", false, "With -template, start a new repository with the copied files")
+	retries := flag.Int("retries", retry.DefaultPolicy.Attempts-1, "Times to retry failed downloads and clones, with exponential backoff (default from the config file)")
+	pull := flag.Bool("pull", false, "Fast-forward an existing clone of the repository when reusing it")
+	archiveType := flag.String("type", "", "Archive format of a download, e.g. tar.gz or zip (default detected)")
+	sha256Sum := flag.String("sha256", "", "Expected SHA-256 checksum of the archive, in hex")
+	sha512Sum := flag.String("sha512", "", "Expected SHA-512 checksum of the archive, in hex")
+	verify := flag.Bool("verify", false, "Verify the archive against the <file>.sha256 or SHA256SUMS files published next to it")
+	sigLocation := flag.String("signature", "", "URL or path of a minisign or SSH signature of the archive, checked against the trusted keys")
+	verifySig := flag.Bool("verify-signature", false, "Verify the archive against the <file>.minisig or <file>.sig published next to it")
+	var trustedKeys stringList
+	flag.Var(&trustedKeys, "trusted-key", "Minisign or SSH public key, or file of keys, to trust signatures from; repeatable (adds to trusted_keys from the config file)")
+	maxSize := byteSize(archive.DefaultLimits.MaxBytes)
+	flag.Var(&maxSize, "max-size", "Maximum total uncompressed archive size, e.g. 500M or 2G (-1 for no limit)")
+	maxEntries := flag.Int("max-entries", archive.DefaultLimits.MaxEntries, "Maximum number of archive entries (-1 for no limit)")
+	maxRatio := flag.Float64("max-ratio", archive.DefaultLimits.MaxRatio, "Maximum archive compression ratio (-1 for no limit)")
+	caFile := flag.String("ca-file", "", "PEM file of certificate authorities to trust for downloads, besides the system ones")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification of downloads (dangerous)")
+	proxy := flag.String("proxy", "", "Proxy URL for downloads, e.g. http://proxy:3128 (default from HTTPS_PROXY)")
+	var headers stringList
+	flag.Var(&headers, "header", "Header to send with downloads, as \"Name: value\"; repeatable")
+	offline := flag.Bool("offline", false, "Only use downloads from the cache, never the network")
+	timeout := flag.Duration("timeout", 0, "Give up after this long, e.g. 30s or 5m (default no timeout)")
 	showVersion := flag.Bool("version", false, "Show version information")
 	flag.Parse()
 
@@ -28,27 +75,163 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Get target path from arguments
-	if flag.NArg() != 1 {
-		fmt.Fprintln(os.Stderr, "Usage: take [-depth N] [-force] <directory|git-url>")
+	// Get the source from the arguments, and the optional destination of
+	// repositories and archives
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: take [options] <directory>")
+		fmt.Fprintln(os.Stderr, "       take [options] <git-url|archive-url> [dest]")
+		fmt.Fprintln(os.Stderr, "       take cache ls|prune [options]")
 		os.Exit(1)
 	}
 
 	target := flag.Arg(0)
 
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *protocol == "" {
+		*protocol = cfg.Protocol
+	}
+	if *protocol != "" && *protocol != "https" && *protocol != "ssh" {
+		fmt.Fprintf(os.Stderr, "invalid protocol %q: use https or ssh\n", *protocol)
+		os.Exit(1)
+	}
+
+	if *archiveType != "" && archive.ParseFormat(*archiveType) == archive.FormatUnknown {
+		fmt.Fprintf(os.Stderr, "invalid archive type %q: use tar, tar.gz, tar.bz2, tar.xz, tar.zst, tar.lz4, zip, gz or xz\n", *archiveType)
+		os.Exit(1)
+	}
+
+	var checksum string
+	switch {
+	case *sha256Sum != "" && *sha512Sum != "":
+		fmt.Fprintln(os.Stderr, "use either -sha256 or -sha512")
+		os.Exit(1)
+	case *sha256Sum != "":
+		checksum = "sha256:" + *sha256Sum
+	case *sha512Sum != "":
+		checksum = "sha512:" + *sha512Sum
+	}
+
+	// Flags win over the config file
+	httpConfig := cfg.HTTP
+	if *caFile != "" {
+		httpConfig.CAFile = *caFile
+	}
+	if *insecure {
+		httpConfig.InsecureSkipVerify = true
+	}
+	if *proxy != "" {
+		httpConfig.Proxy = *proxy
+	}
+	if len(headers) > 0 {
+		httpConfig.Headers = maps.Clone(httpConfig.Headers)
+		if httpConfig.Headers == nil {
+			httpConfig.Headers = make(map[string]string)
+		}
+		for _, header := range headers {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				fmt.Fprintf(os.Stderr, "invalid header %q: use \"Name: value\"\n", header)
+				os.Exit(1)
+			}
+			httpConfig.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	if httpConfig.UserAgent == "" {
+		httpConfig.UserAgent = "take/" + version
+	}
+	httpClient, err := httpclient.New(httpConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if httpConfig.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled. Downloads can be read and tampered with by anyone on the network path.")
+	}
+
+	// The flag wins over the config file
+	retryPolicy := cfg.Retry
+	if isFlagSet("retries") {
+		retryPolicy.Attempts = max(*retries, 0) + 1
+	}
+
+	// The flag wins over the environment, which wins over the config file
+	cloneRoot := *root
+	if cloneRoot == "" {
+		cloneRoot = os.Getenv("TAKE_ROOT")
+	}
+	if cloneRoot == "" {
+		cloneRoot = cfg.CloneRoot
+	}
+
 	// Create options
 	opts := take.Options{
-		Path:          target,
-		GitCloneDepth: *depth,
-		Force:         *force,
+		Path:                target,
+		GitCloneDepth:       *depth,
+		Force:               *force,
+		Forges:              cfg.Forges,
+		DefaultForge:        cfg.DefaultForge,
+		GitProtocol:         *protocol,
+		CloneRoot:           cloneRoot,
+		HTTPClient:          httpClient,
+		CacheDir:            cfg.CacheDir,
+		Offline:             *offline,
+		Retry:               retryPolicy,
+		Ref:                 *ref,
+		CloneFilter:         *filter,
+		Sparse:              splitList(*sparse),
+		Submodules:          *recursive,
+		Template:            *template,
+		TemplateInit:        *initRepo,
+		TargetDir:           flag.Arg(1),
+		Pull:                *pull,
+		ArchiveType:         *archiveType,
+		Checksum:            checksum,
+		VerifyChecksum:      *verify,
+		TrustedKeys:         append(cfg.TrustedKeys, trustedKeys...),
+		Signature:           *sigLocation,
+		VerifySignature:     *verifySig,
+		MaxExtractSize:      int64(maxSize),
+		MaxExtractEntries:   *maxEntries,
+		MaxCompressionRatio: *maxRatio,
+		Progress:            take.NewProgressPrinter(os.Stderr),
+	}
+
+	// Ctrl-C and the timeout cancel the operation, which cleans up after
+	// itself before take exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
 
 	// Execute take command
-	result := take.Take(opts)
+	result := take.TakeContext(ctx, opts)
 	if result.Error != nil {
-		fmt.Fprintln(os.Stderr, result.Error)
+		switch {
+		case errors.Is(result.Error, context.DeadlineExceeded):
+			fmt.Fprintf(os.Stderr, "timed out after %v: %v\n", *timeout, result.Error)
+		case errors.Is(result.Error, context.Canceled):
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(130)
+		default:
+			fmt.Fprintln(os.Stderr, result.Error)
+		}
 		os.Exit(1)
 	}
+	if sig := result.Signature; sig != nil {
+		fmt.Fprintf(os.Stderr, "Good %s signature from key %s", sig.Format, sig.KeyID)
+		if sig.Comment != "" {
+			fmt.Fprintf(os.Stderr, " (%s)", sig.Comment)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
 
 	// Print the final path to stdout
 	// This will be used by the shell function to cd into the directory
@@ -56,7 +239,7 @@ func main() {
 }
 
 // handleGitURL handles git repository cloning
-func handleGitURL(url string, depth int) (string, error) {
+func handleGitURL(ctx context.Context, url string, depth int) (string, error) {
 	if !git.IsGitInstalled() {
 		return "", fmt.Errorf("git is not installed")
 	}
@@ -66,7 +249,7 @@ func handleGitURL(url string, depth int) (string, error) {
 	targetDir := filepath.Join(".", repoName)
 
 	// Clone the repository
-	err := git.Clone(git.CloneOptions{
+	err := git.Clone(ctx, git.CloneOptions{
 		URL:       url,
 		TargetDir: targetDir,
 		Depth:     depth,
@@ -77,3 +260,66 @@ func handleGitURL(url string, depth int) (string, error) {
 
 	return targetDir, nil
 }
+
+// byteSize is a flag value accepting sizes with an optional K, M, G or T
+// suffix (powers of 1024)
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "B")
+
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = 1 << (10 * (i + 1))
+			value = strings.TrimSuffix(value, suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
+
+// stringList is a flag value collecting every occurrence of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isFlagSet reports whether the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -5,13 +5,44 @@ _take() {
     COMPREPLY=()
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
-    opts="-depth -force -version"
+    opts="-depth -force -root -protocol -ref -branch -filter -sparse -recursive -template -init -pull -type -sha256 -sha512 -verify -signature -verify-signature -trusted-key -max-size -max-entries -max-ratio -retries -ca-file -insecure -proxy -header -offline -timeout -version"
 
     case "${prev}" in
         -depth)
             COMPREPLY=( $(compgen -W "1 5 10" -- ${cur}) )
             return 0
             ;;
+        -retries)
+            COMPREPLY=( $(compgen -W "0 2 5" -- ${cur}) )
+            return 0
+            ;;
+        -timeout)
+            COMPREPLY=( $(compgen -W "30s 1m 5m" -- ${cur}) )
+            return 0
+            ;;
+        -protocol)
+            COMPREPLY=( $(compgen -W "https ssh" -- ${cur}) )
+            return 0
+            ;;
+        -root)
+            COMPREPLY=( $(compgen -d -- ${cur}) )
+            return 0
+            ;;
+        -type)
+            COMPREPLY=( $(compgen -W "tar tar.gz tar.bz2 tar.xz tar.zst tar.lz4 zip gz xz" -- ${cur}) )
+            return 0
+            ;;
+        -signature|-trusted-key|-ca-file)
+            COMPREPLY=( $(compgen -f -- ${cur}) )
+            return 0
+            ;;
+        -filter)
+            COMPREPLY=( $(compgen -W "blob:none tree:0" -- ${cur}) )
+            return 0
+            ;;
+        -ref|-branch|-sparse|-sha256|-sha512|-proxy|-header)
+            return 0
+            ;;
         take)
             # Complete directories and git URLs
             if [[ ${cur} == git@* || ${cur} == https://* ]]; then
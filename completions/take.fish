@@ -1,5 +1,32 @@
 complete -c take -l depth -d 'Git clone depth (0 for full clone)' -xa '1 5 10'
-complete -c take -l force -d 'Force operation even if directory exists'
+complete -c take -l force -d 'Replace an existing clone or extracted directory'
+complete -c take -l root -d 'Clone repositories into <root>/<host>/<owner>/<repo>' -xa '(__fish_complete_directories)'
+complete -c take -l protocol -d 'Protocol forge shorthands expand to' -xa 'https ssh'
+complete -c take -l ref -d 'Branch, tag or commit to check out' -x
+complete -c take -l branch -d 'Branch, tag or commit to check out' -x
+complete -c take -l filter -d 'Partial clone filter' -xa 'blob:none tree:0'
+complete -c take -l sparse -d 'Comma-separated directories to check out with a sparse checkout' -x
+complete -c take -l recursive -d 'Initialize git submodules recursively'
+complete -c take -l template -d 'Copy a repository without its history'
+complete -c take -l init -d 'With -template, start a new repository with the copied files'
+complete -c take -l pull -d 'Fast-forward an existing clone of the repository when reusing it'
+complete -c take -l type -d 'Archive format of a download' -xa 'tar tar.gz tar.bz2 tar.xz tar.zst tar.lz4 zip gz xz'
+complete -c take -l sha256 -d 'Expected SHA-256 checksum of the archive' -x
+complete -c take -l sha512 -d 'Expected SHA-512 checksum of the archive' -x
+complete -c take -l verify -d 'Verify the archive against the checksum files published next to it'
+complete -c take -l signature -d 'URL or path of a signature of the archive' -rF
+complete -c take -l verify-signature -d 'Verify the archive against the signature published next to it'
+complete -c take -l trusted-key -d 'Public key or key file to trust signatures from' -rF
+complete -c take -l max-size -d 'Maximum total uncompressed archive size' -x
+complete -c take -l max-entries -d 'Maximum number of archive entries' -x
+complete -c take -l max-ratio -d 'Maximum archive compression ratio' -x
+complete -c take -l retries -d 'Times to retry failed downloads and clones' -xa '0 2 5'
+complete -c take -l ca-file -d 'PEM file of certificate authorities to trust for downloads' -rF
+complete -c take -l insecure -d 'Skip TLS certificate verification of downloads'
+complete -c take -l proxy -d 'Proxy URL for downloads' -x
+complete -c take -l header -d 'Header to send with downloads' -x
+complete -c take -l offline -d 'Only use downloads from the cache'
+complete -c take -l timeout -d 'Give up after this long' -xa '30s 1m 5m'
 complete -c take -l version -d 'Show version information'
 
 # Directory completion
@@ -1,3 +1,14 @@
 module github.com/deblasis/take
 
 go 1.23.3
+
+require (
+	aead.dev/minisign v0.2.0
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.30
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.36.0
+	golang.org/x/term v0.30.0
+)
+
+require golang.org/x/sys v0.31.0 // indirect
@@ -0,0 +1,577 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	ErrUnsafeEntry       = errors.New("unsafe archive entry")
+)
+
+// Format identifies the container and compression of an archive
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatZip
+	FormatTar
+	FormatTarGz
+	FormatTarBz2
+	FormatTarXz
+	FormatTarZst
+	FormatTarLz4
+	// FormatGz and FormatXz are single compressed files rather than
+	// archives
+	FormatGz
+	FormatXz
+)
+
+// String returns the canonical file extension of the format
+func (f Format) String() string {
+	switch f {
+	case FormatZip:
+		return "zip"
+	case FormatTar:
+		return "tar"
+	case FormatTarGz:
+		return "tar.gz"
+	case FormatTarBz2:
+		return "tar.bz2"
+	case FormatTarXz:
+		return "tar.xz"
+	case FormatTarZst:
+		return "tar.zst"
+	case FormatTarLz4:
+		return "tar.lz4"
+	case FormatGz:
+		return "gz"
+	case FormatXz:
+		return "xz"
+	default:
+		return "unknown"
+	}
+}
+
+// extensions maps file name suffixes to formats, longest suffixes first
+var extensions = []struct {
+	suffix string
+	format Format
+}{
+	{".tar.gz", FormatTarGz},
+	{".tar.bz2", FormatTarBz2},
+	{".tar.xz", FormatTarXz},
+	{".tar.zst", FormatTarZst},
+	{".tar.lz4", FormatTarLz4},
+	{".tgz", FormatTarGz},
+	{".tbz2", FormatTarBz2},
+	{".txz", FormatTarXz},
+	{".tzst", FormatTarZst},
+	{".tar", FormatTar},
+	{".zip", FormatZip},
+	{".gz", FormatGz},
+	{".xz", FormatXz},
+}
+
+// FormatFromName detects the archive format from a file name or URL path
+func FormatFromName(name string) Format {
+	lower := strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(lower, ext.suffix) {
+			return ext.format
+		}
+	}
+	return FormatUnknown
+}
+
+// TrimExt removes a known archive extension from a file name
+func TrimExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(lower, ext.suffix) {
+			return name[:len(name)-len(ext.suffix)]
+		}
+	}
+	return name
+}
+
+// Extract unpacks the archive at src into the dst directory. Entries whose
+// path, symlink or hardlink target would land outside dst are rejected with
+// ErrUnsafeEntry, and archives breaking limits with ErrLimitExceeded. Once
+// every entry is written, the symlinks are resolved again against the
+// directory that FindRoot picks, since that is all that gets installed.
+// Extraction stops with the context's error once ctx is done. On failure the
+// partially extracted dst is removed.
+func Extract(ctx context.Context, src string, format Format, dst string, limits Limits) (err error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dst)
+		}
+	}()
+
+	e, err := newExtractor(ctx, dst, limits)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatZip {
+		err = e.extractZip(src)
+	} else {
+		err = e.extractFile(src, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	root := e.dst
+	if rootDir, ok := FindRoot(e.dst); ok {
+		root = filepath.Join(e.dst, rootDir)
+	}
+	return checkLinks(root)
+}
+
+// extractFile extracts the tarball or single compressed file at src
+func (e *extractor) extractFile(src string, format Format) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Tar entries share one compressed stream, so the ratio is measured
+	// against everything read from the archive so far
+	compressed := &countingReader{r: f}
+	e.ratioIn = func() int64 { return compressed.n }
+
+	r, err := decompress(compressed, format)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Single compressed files are named after the archive
+	if format == FormatGz || format == FormatXz {
+		if err := e.addEntry(); err != nil {
+			return err
+		}
+		return e.file(TrimExt(filepath.Base(src)), r, 0644)
+	}
+
+	return e.extractTar(r)
+}
+
+// FindRoot returns the name of the single top-level directory of an
+// extracted archive. Entries starting with "." or "_" (such as __MACOSX or
+// pax headers) are ignored. It returns false if the archive has no single
+// root directory.
+func FindRoot(dir string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	var root string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		if !entry.IsDir() || root != "" {
+			return "", false
+		}
+		root = name
+	}
+
+	return root, root != ""
+}
+
+// decompress wraps r with the decompressor matching the format
+func decompress(r io.Reader, format Format) (io.ReadCloser, error) {
+	switch format {
+	case FormatTar:
+		return io.NopCloser(r), nil
+	case FormatTarGz, FormatGz:
+		return gzip.NewReader(r)
+	case FormatTarBz2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case FormatTarXz, FormatXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case FormatTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case FormatTarLz4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// maxLinkTarget bounds the symlink target read from a zip entry
+const maxLinkTarget = 4096
+
+// extractor writes archive entries below a root directory, refusing any
+// entry that would escape it
+type extractor struct {
+	// ctx stops the extraction when it is done
+	ctx context.Context
+	// dst is the absolute extraction root
+	dst string
+	// resolved is dst with all symlinks evaluated
+	resolved string
+	// limits guards against archive bombs
+	limits Limits
+	// entries and written count the entries and bytes extracted so far
+	entries int
+	written int64
+	// ratioIn returns the compressed bytes consumed by the current ratio
+	// window and ratioOut counts the bytes it has produced
+	ratioIn  func() int64
+	ratioOut int64
+}
+
+// newExtractor creates an extractor rooted at dst
+func newExtractor(ctx context.Context, dst string, limits Limits) (*extractor, error) {
+	// Symlinks are resolved from the filesystem root, so a relative root
+	// would never contain them
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{ctx: ctx, dst: dst, resolved: resolved, limits: limits}, nil
+}
+
+// extractTar extracts a tar stream
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %v", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			if err := e.addEntry(); err != nil {
+				return err
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.dir(header.Name)
+		case tar.TypeReg:
+			err = e.file(header.Name, tr, header.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = e.link(header.Name, header.Linkname)
+		default:
+			// Skip pax headers, devices, fifos and other special entries
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts the zip file at src
+func (e *extractor) extractZip(src string) error {
+	zipReader, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %v", err)
+	}
+	defer zipReader.Close()
+
+	for _, file := range zipReader.File {
+		if err := e.addEntry(); err != nil {
+			return err
+		}
+		compressed := int64(file.CompressedSize64)
+		e.ratioIn = func() int64 { return compressed }
+		e.ratioOut = 0
+
+		mode := file.Mode()
+		if mode.IsDir() {
+			if err := e.dir(file.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		srcFile, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open file in zip: %v", err)
+		}
+		if mode&os.ModeSymlink != 0 {
+			// Zip stores the symlink target as the entry's content
+			var target []byte
+			target, err = io.ReadAll(io.LimitReader(srcFile, maxLinkTarget))
+			if err == nil {
+				err = e.symlink(file.Name, string(target))
+			}
+		} else {
+			err = e.file(file.Name, srcFile, mode.Perm())
+		}
+		srcFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dir creates the directory entry name
+func (e *extractor) dir(name string) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return nil
+}
+
+// file creates the file entry name with the given permissions and copies r
+// into it
+func (e *extractor) file(name string, r io.Reader, perm os.FileMode) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+
+	dstFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+
+	err = e.copy(dstFile, r)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract file: %w", err)
+	}
+	return nil
+}
+
+// symlink creates the symlink entry name pointing at target, which must be
+// relative and resolve inside the extraction root
+func (e *extractor) symlink(name, target string) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	target = filepath.FromSlash(target)
+	if target == "" || filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+		!within(e.dst, filepath.Join(filepath.Dir(path), target)) {
+		return unsafeEntry(name + " -> " + target)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return fmt.Errorf("failed to create symlink: %v", err)
+	}
+
+	// A target that is lexically inside the root may still escape through
+	// symlinks extracted earlier, even when it doesn't exist yet
+	if resolved, err := resolvePath(path); err != nil || !within(e.resolved, resolved) {
+		os.Remove(path)
+		return unsafeEntry(name + " -> " + target)
+	}
+	return nil
+}
+
+// checkLinks resolves every symlink below root and rejects those leading
+// outside it. Each link is checked against root when it is extracted, but
+// entries written later can still redirect it: a link may point through a
+// directory that is only then replaced by another link.
+func checkLinks(root string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		name, _ := filepath.Rel(root, path)
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if resolved, err := resolvePath(path); err != nil || !within(resolvedRoot, resolved) {
+			return unsafeEntry(filepath.ToSlash(name) + " -> " + target)
+		}
+		return nil
+	})
+}
+
+// maxLinkHops bounds the symlinks resolvePath follows, which stops loops
+const maxLinkHops = 255
+
+// resolvePath evaluates the symlinks of the absolute path one component at
+// a time, like the kernel does when the path is opened. Unlike
+// filepath.EvalSymlinks it also resolves dangling links: components that
+// don't exist are taken as they are.
+func resolvePath(path string) (string, error) {
+	volume := filepath.VolumeName(path)
+	resolved := volume + string(filepath.Separator)
+	pending := splitPath(path[len(volume):])
+
+	hops := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxLinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			volume := filepath.VolumeName(target)
+			resolved = volume + string(filepath.Separator)
+			target = target[len(volume):]
+		}
+		pending = append(splitPath(target), pending...)
+	}
+	return resolved, nil
+}
+
+// splitPath splits path into its components, without cleaning it
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// link creates the hardlink entry name pointing at target, which is relative
+// to the extraction root
+func (e *extractor) link(name, target string) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	targetPath, err := e.path(target)
+	if err != nil {
+		return unsafeEntry(name + " => " + target)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+	if err := os.Link(targetPath, path); err != nil {
+		return fmt.Errorf("failed to create hard link: %v", err)
+	}
+	return nil
+}
+
+// path returns the location of the entry name below the extraction root.
+// It rejects absolute names, names that climb out of the root with "..",
+// and names whose existing parent directories are symlinks leading outside
+// the root.
+func (e *extractor) path(name string) (string, error) {
+	clean := filepath.FromSlash(name)
+	if clean == "" || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(name, "/") {
+		return "", unsafeEntry(name)
+	}
+
+	path := filepath.Join(e.dst, clean)
+	if !within(e.dst, path) {
+		return "", unsafeEntry(name)
+	}
+
+	// Follow the deepest part of the path that already exists on disk
+	existing := path
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == e.dst {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil || !within(e.resolved, resolved) {
+		return "", unsafeEntry(name)
+	}
+
+	return path, nil
+}
+
+// within reports whether path is root or lies below it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// unsafeEntry wraps ErrUnsafeEntry with the offending entry
+func unsafeEntry(name string) error {
+	return fmt.Errorf("%w: %s", ErrUnsafeEntry, name)
+}
@@ -0,0 +1,674 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+type testEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+}
+
+// writeTar writes the entries as a tar stream to w
+func writeTar(t *testing.T, w io.Writer, entries []testEntry) {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatalf("Failed to write tar body: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+}
+
+// createArchive writes the entries to a file in the given format
+func createArchive(t *testing.T, dir string, format Format, entries []testEntry) string {
+	path := filepath.Join(dir, "test."+format.String())
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	switch format {
+	case FormatZip:
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			w, err := zw.Create(e.name)
+			if err != nil {
+				t.Fatalf("Failed to create zip entry: %v", err)
+			}
+			if _, err := w.Write([]byte(e.body)); err != nil {
+				t.Fatalf("Failed to write zip entry: %v", err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Failed to close zip writer: %v", err)
+		}
+	case FormatTar:
+		writeTar(t, f, entries)
+	case FormatTarGz:
+		gw := gzip.NewWriter(f)
+		writeTar(t, gw, entries)
+		gw.Close()
+	case FormatTarXz:
+		xw, err := xz.NewWriter(f)
+		if err != nil {
+			t.Fatalf("Failed to create xz writer: %v", err)
+		}
+		writeTar(t, xw, entries)
+		xw.Close()
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			t.Fatalf("Failed to create zstd writer: %v", err)
+		}
+		writeTar(t, zw, entries)
+		zw.Close()
+	case FormatTarLz4:
+		lw := lz4.NewWriter(f)
+		writeTar(t, lw, entries)
+		lw.Close()
+	default:
+		t.Fatalf("Unsupported test format %v", format)
+	}
+
+	return path
+}
+
+func TestFormatFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+	}{
+		{"archive.tar.gz", FormatTarGz},
+		{"archive.tgz", FormatTarGz},
+		{"archive.tar.bz2", FormatTarBz2},
+		{"archive.tar.xz", FormatTarXz},
+		{"ARCHIVE.ZIP", FormatZip},
+		{"archive.tar", FormatTar},
+		{"archive.tar.zst", FormatTarZst},
+		{"archive.tzst", FormatTarZst},
+		{"archive.tar.lz4", FormatTarLz4},
+		{"data.json.gz", FormatGz},
+		{"data.json.xz", FormatXz},
+		{"archive.txt", FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatFromName(tt.name); got != tt.want {
+				t.Errorf("FormatFromName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimExt(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"v1.2.tar.gz", "v1.2"},
+		{"project.zip", "project"},
+		{"build.tar.zst", "build"},
+		{"data.json.gz", "data.json"},
+		{"plain", "plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TrimExt(tt.name); got != tt.want {
+				t.Errorf("TrimExt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	entries := []testEntry{
+		{name: "project/", typeflag: tar.TypeDir},
+		{name: "project/README.md", body: "readme", typeflag: tar.TypeReg},
+		{name: "project/src/main.go", body: "package main", typeflag: tar.TypeReg},
+	}
+
+	for _, format := range []Format{FormatTar, FormatTarGz, FormatTarXz, FormatTarZst, FormatTarLz4, FormatZip} {
+		t.Run(format.String(), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createArchive(t, tmpDir, format, entries)
+			dst := filepath.Join(tmpDir, "out")
+
+			if err := Extract(context.Background(), src, format, dst, DefaultLimits); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			root, ok := FindRoot(dst)
+			if !ok || root != "project" {
+				t.Fatalf("FindRoot() = %q, %v, want %q, true", root, ok, "project")
+			}
+
+			content, err := os.ReadFile(filepath.Join(dst, "project", "src", "main.go"))
+			if err != nil {
+				t.Fatalf("Failed to read extracted file: %v", err)
+			}
+			if string(content) != "package main" {
+				t.Errorf("Extracted content = %q, want %q", content, "package main")
+			}
+		})
+	}
+}
+
+func TestExtractCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := createArchive(t, tmpDir, FormatTarGz, []testEntry{
+		{name: "project/README.md", body: "readme", typeflag: tar.TypeReg},
+	})
+	dst := filepath.Join(tmpDir, "out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Extract(ctx, src, FormatTarGz, dst, DefaultLimits); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Extract() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Extract() left %s behind", dst)
+	}
+}
+
+func TestExtractSingleFile(t *testing.T) {
+	content := bytes.Repeat([]byte(`{"key": "value"}`), 100)
+
+	tests := []struct {
+		name    string
+		format  Format
+		limits  Limits
+		wantErr error
+	}{
+		{name: "data.json.gz", format: FormatGz, limits: DefaultLimits},
+		{name: "data.json.xz", format: FormatXz, limits: DefaultLimits},
+		{name: "big.json.gz", format: FormatGz, limits: Limits{MaxBytes: 100}, wantErr: ErrLimitExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := filepath.Join(tmpDir, tt.name)
+			f, err := os.Create(src)
+			if err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+			var w io.WriteCloser
+			if tt.format == FormatGz {
+				w = gzip.NewWriter(f)
+			} else if w, err = xz.NewWriter(f); err != nil {
+				t.Fatalf("Failed to create xz writer: %v", err)
+			}
+			w.Write(content)
+			w.Close()
+			f.Close()
+
+			dst := filepath.Join(tmpDir, "out")
+			err = Extract(context.Background(), src, tt.format, dst, tt.limits)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Extract() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dst, TrimExt(tt.name)))
+			if err != nil {
+				t.Fatalf("Failed to read extracted file: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("Extracted %d bytes, want %d", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestExtractTarLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := createArchive(t, tmpDir, FormatTarGz, []testEntry{
+		{name: "project/file.txt", body: "content", typeflag: tar.TypeReg},
+		{name: "project/symlink.txt", typeflag: tar.TypeSymlink, linkname: "file.txt"},
+		{name: "project/hardlink.txt", typeflag: tar.TypeLink, linkname: "project/file.txt"},
+	})
+	dst := filepath.Join(tmpDir, "out")
+
+	if err := Extract(context.Background(), src, FormatTarGz, dst, DefaultLimits); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	for _, name := range []string{"symlink.txt", "hardlink.txt"} {
+		content, err := os.ReadFile(filepath.Join(dst, "project", name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(content) != "content" {
+			t.Errorf("%s content = %q, want %q", name, content, "content")
+		}
+	}
+}
+
+func TestExtractUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		entries []testEntry
+	}{
+		{
+			name:    "parent traversal",
+			format:  FormatTarGz,
+			entries: []testEntry{{name: "../../evil.txt", body: "evil", typeflag: tar.TypeReg}},
+		},
+		{
+			name:    "nested parent traversal",
+			format:  FormatTar,
+			entries: []testEntry{{name: "project/../../evil.txt", body: "evil", typeflag: tar.TypeReg}},
+		},
+		{
+			name:    "absolute path",
+			format:  FormatTar,
+			entries: []testEntry{{name: "/tmp/evil.txt", body: "evil", typeflag: tar.TypeReg}},
+		},
+		{
+			name:    "zip slip",
+			format:  FormatZip,
+			entries: []testEntry{{name: "../evil.txt", body: "evil"}},
+		},
+		{
+			name:    "absolute symlink target",
+			format:  FormatTar,
+			entries: []testEntry{{name: "project/passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+		},
+		{
+			name:    "escaping symlink target",
+			format:  FormatTar,
+			entries: []testEntry{{name: "project/up", typeflag: tar.TypeSymlink, linkname: "../.."}},
+		},
+		{
+			name:    "escaping hardlink target",
+			format:  FormatTar,
+			entries: []testEntry{{name: "project/passwd", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+		},
+		{
+			name:   "write through symlinked directory",
+			format: FormatTar,
+			entries: []testEntry{
+				{name: "project/a/", typeflag: tar.TypeDir},
+				{name: "project/a/up", typeflag: tar.TypeSymlink, linkname: "../.."},
+				{name: "project/out", typeflag: tar.TypeSymlink, linkname: "a/up/.."},
+				{name: "project/out/evil.txt", body: "evil", typeflag: tar.TypeReg},
+			},
+		},
+		{
+			// The target doesn't exist, so only resolving it through the
+			// loop of p one step at a time shows that it climbs out
+			name:   "dangling symlink escaping through a link loop",
+			format: FormatTar,
+			entries: []testEntry{
+				{name: "p", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "z", typeflag: tar.TypeSymlink, linkname: "p/p/p/p/../../../../escaped-target"},
+			},
+		},
+		{
+			// a is safe when it is written, until s/x becomes a link
+			name:   "symlink redirected by a later entry",
+			format: FormatTar,
+			entries: []testEntry{
+				{name: "root/a", typeflag: tar.TypeSymlink, linkname: "s/x/.."},
+				{name: "root/s/", typeflag: tar.TypeDir},
+				{name: "root/s/x", typeflag: tar.TypeSymlink, linkname: "../.."},
+			},
+		},
+		{
+			// The target is the extraction root, which is outside the
+			// root directory that gets installed
+			name:   "symlink escaping the archive root directory",
+			format: FormatTar,
+			entries: []testEntry{
+				{name: "root/s/", typeflag: tar.TypeDir},
+				{name: "root/s/x", typeflag: tar.TypeSymlink, linkname: "../.."},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createArchive(t, tmpDir, tt.format, tt.entries)
+			dst := filepath.Join(tmpDir, "nested", "out")
+
+			err := Extract(context.Background(), src, tt.format, dst, DefaultLimits)
+			if !errors.Is(err, ErrUnsafeEntry) {
+				t.Fatalf("Extract() error = %v, want %v", err, ErrUnsafeEntry)
+			}
+
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				t.Error("Expected partially extracted directory to be removed")
+			}
+			for _, path := range []string{
+				filepath.Join(tmpDir, "evil.txt"),
+				filepath.Join(tmpDir, "nested", "evil.txt"),
+			} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Entry was written outside the extraction root: %s", path)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	zeros := string(make([]byte, 4<<20))
+	entries := []testEntry{
+		{name: "project/a.txt", body: "aaaa", typeflag: tar.TypeReg},
+		{name: "project/b.txt", body: "bbbb", typeflag: tar.TypeReg},
+		{name: "project/c.txt", body: "cccc", typeflag: tar.TypeReg},
+	}
+
+	tests := []struct {
+		name    string
+		format  Format
+		entries []testEntry
+		limits  Limits
+		wantErr bool
+	}{
+		{
+			name:    "within limits",
+			format:  FormatTarGz,
+			entries: entries,
+			limits:  Limits{MaxBytes: 12, MaxEntries: 3},
+		},
+		{
+			name:    "too many tar entries",
+			format:  FormatTarGz,
+			entries: entries,
+			limits:  Limits{MaxEntries: 2},
+			wantErr: true,
+		},
+		{
+			name:    "too many zip entries",
+			format:  FormatZip,
+			entries: entries,
+			limits:  Limits{MaxEntries: 2},
+			wantErr: true,
+		},
+		{
+			name:    "tar too large",
+			format:  FormatTar,
+			entries: entries,
+			limits:  Limits{MaxBytes: 10},
+			wantErr: true,
+		},
+		{
+			name:    "zip too large",
+			format:  FormatZip,
+			entries: entries,
+			limits:  Limits{MaxBytes: 10},
+			wantErr: true,
+		},
+		{
+			name:    "tar ratio exceeded",
+			format:  FormatTarGz,
+			entries: []testEntry{{name: "bomb", body: zeros, typeflag: tar.TypeReg}},
+			limits:  Limits{MaxRatio: 100},
+			wantErr: true,
+		},
+		{
+			name:    "zip ratio exceeded",
+			format:  FormatZip,
+			entries: []testEntry{{name: "bomb", body: zeros}},
+			limits:  Limits{MaxRatio: 100},
+			wantErr: true,
+		},
+		{
+			name:    "ratio disabled",
+			format:  FormatZip,
+			entries: []testEntry{{name: "bomb", body: zeros}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := createArchive(t, tmpDir, tt.format, tt.entries)
+			dst := filepath.Join(tmpDir, "out")
+
+			err := Extract(context.Background(), src, tt.format, dst, tt.limits)
+			if tt.wantErr {
+				if !errors.Is(err, ErrLimitExceeded) {
+					t.Fatalf("Extract() error = %v, want %v", err, ErrLimitExceeded)
+				}
+				if _, err := os.Stat(dst); !os.IsNotExist(err) {
+					t.Error("Expected partially extracted directory to be removed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() unexpected error = %v", err)
+			}
+		})
+	}
+}
+
+func TestFindRoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirs     []string
+		files    []string
+		wantRoot string
+		wantOK   bool
+	}{
+		{
+			name:     "single root directory",
+			dirs:     []string{"project"},
+			wantRoot: "project",
+			wantOK:   true,
+		},
+		{
+			name:     "ignore hidden and underscore entries",
+			dirs:     []string{"project", "__MACOSX"},
+			files:    []string{".DS_Store"},
+			wantRoot: "project",
+			wantOK:   true,
+		},
+		{
+			name: "multiple top-level directories",
+			dirs: []string{"a", "b"},
+		},
+		{
+			name:  "top-level files",
+			dirs:  []string{"project"},
+			files: []string{"README.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, d := range tt.dirs {
+				if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+					t.Fatalf("Failed to create dir: %v", err)
+				}
+			}
+			for _, f := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+			}
+
+			root, ok := FindRoot(dir)
+			if root != tt.wantRoot || ok != tt.wantOK {
+				t.Errorf("FindRoot() = %q, %v, want %q, %v", root, ok, tt.wantRoot, tt.wantOK)
+			}
+		})
+	}
+}
+
+// Frames written by the lz4 tool
+var (
+	// lz4 small.lz4: independent blocks and a content checksum
+	lz4Small = "BCJNGGRApw8AAABtaGVsbG8gBgBQIGx6NAoAAAAAfXyJKw=="
+	// lz4 -BD -BX -B4 --content-size: dependent 64KB blocks, block
+	// checksums and the content size, holding lz4Repeated
+	lz4Dependent = "" +
+		"BCJNGFxAUDQDAAAAAABbOAEAAHF0YWtlIDAKBwASMQcAEjIHABIzBwASNAcAEjUHABI2BwASNwcA" +
+		"EjgHABI5BwAPRgD/////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////nVAgMQp0YUqfOvAKAQAAD/D/" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"/////////////////////////////////////+hQCnRha2V/XWxINgEAAJIgNAp0YWtlIDUHABI2" +
+		"BwASNwcAEjgHABI5BwASMAcAEjEHABIyBwASMwcAD0YA////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"////////////////////////////////////////////////////////////////////////////" +
+		"/////6FQYWtlIDbDJTi+QQAAAAL+/w/w////////////////////////////////////////////" +
+		"//////////////////////////9mUGtlIDkKuANkrQAAAADsv/WF" +
+		""
+)
+
+// lz4Repeated is the content of lz4Dependent
+func lz4Repeated() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 30000; i++ {
+		fmt.Fprintf(&b, "take %d\n", i%10)
+	}
+	return b.Bytes()
+}
+
+func decodeBase64(t *testing.T, s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Failed to decode fixture: %v", err)
+	}
+	return b
+}
+
+func TestDecompressLZ4(t *testing.T) {
+	small := decodeBase64(t, lz4Small)
+	dependent := decodeBase64(t, lz4Dependent)
+	smallText := []byte("hello hello hello hello lz4\n")
+	skippable := []byte("\x50\x2a\x4d\x18\x03\x00\x00\x00abc")
+
+	corrupt := bytes.Clone(dependent)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:  "independent blocks",
+			input: small,
+			want:  smallText,
+		},
+		{
+			name:  "dependent blocks",
+			input: dependent,
+			want:  lz4Repeated(),
+		},
+		{
+			name:  "concatenated and skippable frames",
+			input: bytes.Join([][]byte{small, skippable, small}, nil),
+			want:  bytes.Repeat(smallText, 2),
+		},
+		{
+			name:  "empty input",
+			input: nil,
+			want:  nil,
+		},
+		{
+			name:    "corrupt block",
+			input:   corrupt,
+			wantErr: true,
+		},
+		{
+			name:    "truncated frame",
+			input:   dependent[:len(dependent)-10],
+			wantErr: true,
+		},
+		{
+			name:    "bad magic",
+			input:   []byte("not lz4 data"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := decompress(bytes.NewReader(tt.input), FormatTarLz4)
+			if err != nil {
+				t.Fatalf("decompress() error = %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Read() succeeded on a corrupt frame")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Read() = %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+// decompress only handles tar streams; zip needs random access
+func TestDecompressUnknownFormat(t *testing.T) {
+	if _, err := decompress(bytes.NewReader(nil), FormatZip); err != ErrUnsupportedFormat {
+		t.Errorf("decompress() error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
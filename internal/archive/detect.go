@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes needed to recognize every format;
+// the tar magic sits at offset 257
+const sniffLen = 262
+
+// signatures are the magic numbers of the supported formats. Compressed
+// streams are assumed to contain a tar archive.
+var signatures = []struct {
+	magic  []byte
+	format Format
+}{
+	{[]byte("PK\x03\x04"), FormatZip},
+	{[]byte("PK\x05\x06"), FormatZip}, // empty zip
+	{[]byte("\x1f\x8b"), FormatTarGz},
+	{[]byte("BZh"), FormatTarBz2},
+	{[]byte("\xfd7zXZ\x00"), FormatTarXz},
+	{[]byte("\x28\xb5\x2f\xfd"), FormatTarZst},
+	{[]byte("\x04\x22\x4d\x18"), FormatTarLz4},
+}
+
+// Sniff identifies the archive format from the first bytes of an archive.
+// It returns FormatUnknown when none of the signatures match.
+func Sniff(header []byte) Format {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(header, sig.magic) {
+			return sig.format
+		}
+	}
+	// POSIX and GNU tar headers carry "ustar" at offset 257
+	if len(header) >= sniffLen && bytes.Equal(header[257:sniffLen], []byte("ustar")) {
+		return FormatTar
+	}
+	return FormatUnknown
+}
+
+// DetectFile identifies the format of the archive at path by its content,
+// falling back to the file name for archives without a signature, such as
+// pre-POSIX tar files. Gzip and xz files that don't hold a tar archive are
+// single compressed files.
+func DetectFile(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, err
+	}
+
+	format := Sniff(header[:n])
+	byName := FormatFromName(path)
+	switch format {
+	case FormatUnknown:
+		if byName == FormatTar {
+			return FormatTar, nil
+		}
+		return FormatUnknown, nil
+	case FormatTarGz, FormatTarXz:
+		if byName == format || containsTar(f, format) {
+			return format, nil
+		}
+		if format == FormatTarGz {
+			return FormatGz, nil
+		}
+		return FormatXz, nil
+	}
+	return format, nil
+}
+
+// containsTar reports whether the compressed file f starts with a tar header
+func containsTar(f *os.File, format Format) bool {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	r, err := decompress(f, format)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	header := make([]byte, sniffLen)
+	n, _ := io.ReadFull(r, header)
+	return Sniff(header[:n]) == FormatTar
+}
+
+// ParseFormat returns the format named by an extension such as "tar.gz",
+// "tgz" or "zip", with or without the leading dot
+func ParseFormat(name string) Format {
+	ext := "." + strings.TrimPrefix(strings.ToLower(name), ".")
+	for _, e := range extensions {
+		if ext == e.suffix {
+			return e.format
+		}
+	}
+	return FormatUnknown
+}
+
+// contentTypes maps the media types servers send for archives to formats.
+// Compressed streams are assumed to contain a tar archive.
+var contentTypes = map[string]Format{
+	"application/zip":              FormatZip,
+	"application/x-zip-compressed": FormatZip,
+	"application/x-tar":            FormatTar,
+	"application/gzip":             FormatTarGz,
+	"application/x-gzip":           FormatTarGz,
+	"application/x-gtar":           FormatTarGz,
+	"application/x-tgz":            FormatTarGz,
+	"application/x-bzip2":          FormatTarBz2,
+	"application/x-xz":             FormatTarXz,
+	"application/zstd":             FormatTarZst,
+	"application/x-lz4":            FormatTarLz4,
+}
+
+// FormatFromContentType returns the format of an HTTP Content-Type header.
+// Generic types such as application/octet-stream are FormatUnknown.
+func FormatFromContentType(contentType string) Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatUnknown
+	}
+	return contentTypes[mediaType]
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	ustar := make([]byte, 512)
+	copy(ustar[257:], "ustar\x0000")
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   Format
+	}{
+		{"zip", []byte("PK\x03\x04\x14\x00"), FormatZip},
+		{"empty zip", []byte("PK\x05\x06\x00\x00"), FormatZip},
+		{"gzip", []byte("\x1f\x8b\x08\x00"), FormatTarGz},
+		{"bzip2", []byte("BZh91AY&SY"), FormatTarBz2},
+		{"xz", []byte("\xfd7zXZ\x00\x00\x04"), FormatTarXz},
+		{"zstd", []byte("\x28\xb5\x2f\xfd\x04"), FormatTarZst},
+		{"lz4", []byte("\x04\x22\x4d\x18\x60"), FormatTarLz4},
+		{"tar", ustar, FormatTar},
+		{"text", []byte("hello world"), FormatUnknown},
+		{"empty", nil, FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sniff(tt.header); got != tt.want {
+				t.Errorf("Sniff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFile(t *testing.T) {
+	dir := t.TempDir()
+	entries := []testEntry{{name: "project/file.txt", body: "hello"}}
+
+	// A zip archive misnamed as a tarball
+	misnamed := filepath.Join(dir, "misnamed.tar.gz")
+	if err := os.Rename(createArchive(t, dir, FormatZip, entries), misnamed); err != nil {
+		t.Fatalf("Failed to rename archive: %v", err)
+	}
+
+	// Pre-POSIX tar files have no magic, only their name identifies them
+	oldTar := filepath.Join(dir, "old.tar")
+	if err := os.WriteFile(oldTar, make([]byte, 1024), 0644); err != nil {
+		t.Fatalf("Failed to create tar: %v", err)
+	}
+
+	// A gzipped tarball without the .tar in its name, and a gzipped file
+	// that isn't a tarball
+	tarGz := filepath.Join(dir, "release.gz")
+	if err := os.Rename(createArchive(t, dir, FormatTarGz, entries), tarGz); err != nil {
+		t.Fatalf("Failed to rename archive: %v", err)
+	}
+	singleGz := filepath.Join(dir, "data.json.gz")
+	f, err := os.Create(singleGz)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	gw := gzip.NewWriter(f)
+	gw.Write([]byte(`{"key": "value"}`))
+	gw.Close()
+	f.Close()
+
+	text := filepath.Join(dir, "notes.zip")
+	if err := os.WriteFile(text, []byte("not an archive"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    Format
+		wantErr bool
+	}{
+		{"tar.gz", createArchive(t, dir, FormatTarGz, entries), FormatTarGz, false},
+		{"tar.xz", createArchive(t, dir, FormatTarXz, entries), FormatTarXz, false},
+		{"tar", createArchive(t, dir, FormatTar, entries), FormatTar, false},
+		{"tar.zst", createArchive(t, dir, FormatTarZst, entries), FormatTarZst, false},
+		{"tar.lz4", createArchive(t, dir, FormatTarLz4, entries), FormatTarLz4, false},
+		{"gzipped tar named .gz", tarGz, FormatTarGz, false},
+		{"single gzipped file", singleGz, FormatGz, false},
+		{"content wins over name", misnamed, FormatZip, false},
+		{"tar without magic", oldTar, FormatTar, false},
+		{"not an archive", text, FormatUnknown, false},
+		{"missing file", filepath.Join(dir, "missing.zip"), FormatUnknown, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectFile(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+	}{
+		{"tar.gz", FormatTarGz},
+		{".tgz", FormatTarGz},
+		{"ZIP", FormatZip},
+		{"tar", FormatTar},
+		{"txz", FormatTarXz},
+		{"tar.zst", FormatTarZst},
+		{"gz", FormatGz},
+		{"archive.zip", FormatUnknown},
+		{"rar", FormatUnknown},
+		{"", FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseFormat(tt.name); got != tt.want {
+				t.Errorf("ParseFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Format
+	}{
+		{"application/zip", FormatZip},
+		{"application/gzip", FormatTarGz},
+		{"application/x-xz; charset=binary", FormatTarXz},
+		{"application/zstd", FormatTarZst},
+		{"APPLICATION/X-TAR", FormatTar},
+		{"application/octet-stream", FormatUnknown},
+		{"text/html; charset=utf-8", FormatUnknown},
+		{"", FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := FormatFromContentType(tt.contentType); got != tt.want {
+				t.Errorf("FormatFromContentType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrLimitExceeded = errors.New("archive exceeds extraction limit")
+)
+
+// ratioMinBytes is the amount of uncompressed data an entry may produce
+// before its compression ratio is checked, so small highly compressible
+// files don't trip the ratio limit
+const ratioMinBytes = 1 << 20
+
+// Limits guards extraction against archive bombs. A zero field disables the
+// corresponding check.
+type Limits struct {
+	// MaxBytes caps the total uncompressed size of all entries
+	MaxBytes int64
+	// MaxEntries caps the number of entries in the archive
+	MaxEntries int
+	// MaxRatio caps the ratio of uncompressed to compressed bytes. Zip
+	// entries are checked individually; tar streams are checked as a whole
+	// since their entries are compressed together.
+	MaxRatio float64
+}
+
+// DefaultLimits are generous enough for real-world source and release
+// archives while stopping decompression bombs
+var DefaultLimits = Limits{
+	MaxBytes:   10 << 30,
+	MaxEntries: 1000000,
+	MaxRatio:   1000,
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// addEntry records a new entry and enforces MaxEntries. It also stops the
+// extraction once the context is done.
+func (e *extractor) addEntry() error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	e.entries++
+	if e.limits.MaxEntries > 0 && e.entries > e.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, e.limits.MaxEntries)
+	}
+	return nil
+}
+
+// copy copies src to dst while enforcing MaxBytes and MaxRatio
+func (e *extractor) copy(dst io.Writer, src io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			e.written += int64(n)
+			e.ratioOut += int64(n)
+			if e.limits.MaxBytes > 0 && e.written > e.limits.MaxBytes {
+				return fmt.Errorf("%w: uncompressed size exceeds %d bytes", ErrLimitExceeded, e.limits.MaxBytes)
+			}
+			if e.limits.MaxRatio > 0 && e.ratioOut > ratioMinBytes {
+				in := e.ratioIn()
+				if in <= 0 || float64(e.ratioOut)/float64(in) > e.limits.MaxRatio {
+					return fmt.Errorf("%w: compression ratio exceeds %g", ErrLimitExceeded, e.limits.MaxRatio)
+				}
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not in the download cache")
+	ErrBusy     = errors.New("download in progress in another take")
+)
+
+// Cache keeps downloads in a directory, stored once per content under the
+// SHA-256 of their bytes, with an index from each URL to the content last
+// downloaded from it. Interrupted downloads are kept there too, until they
+// are resumed.
+type Cache struct {
+	dir string
+}
+
+// activeGrace is how long after its last write a file may still be in use
+// by another take, so that Prune leaves it alone
+const activeGrace = 10 * time.Minute
+
+// Entry describes the content cached for a URL
+type Entry struct {
+	URL string `json:"url"`
+	// SHA256 is the hex digest of the content
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// ETag and LastModified are the validators the server sent, used to
+	// ask whether the content changed
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// ContentType and ContentDisposition are kept to name and detect the
+	// download as if it came from the server
+	ContentType        string    `json:"content_type,omitempty"`
+	ContentDisposition string    `json:"content_disposition,omitempty"`
+	Stored             time.Time `json:"stored"`
+	// Used is when the content was last taken from the cache or stored
+	Used time.Time `json:"-"`
+	// Partial marks an interrupted download, of which Size bytes were
+	// received. It is only listed, never taken from the cache.
+	Partial bool `json:"-"`
+
+	// path is the file of a partial download
+	path string
+}
+
+// New returns the cache kept in dir, which is created when something is
+// stored
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Dir returns the directory of the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return filepath.Join(c.dir, "downloads", "blobs", sum)
+}
+
+func (c *Cache) indexPath(url string) string {
+	return filepath.Join(c.dir, "downloads", "index", urlKey(url)+".json")
+}
+
+func (c *Cache) partialDir() string {
+	return filepath.Join(c.dir, "partial")
+}
+
+// PartialPath returns the file the interrupted download of url is kept in
+// to be resumed. Its state goes next to it with a .json suffix, recording
+// the URL as "url".
+func (c *Cache) PartialPath(url string) (string, error) {
+	if err := os.MkdirAll(c.partialDir(), 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(c.partialDir(), urlKey(url)), nil
+}
+
+// LockPartial takes the lock of the partial download of url, so that
+// concurrent takes of the same URL never write into the same file. It
+// returns a function releasing the lock, or ErrBusy while another take
+// holds it. A lock whose holder wrote nothing for a while was left by a take
+// that crashed, and is taken over.
+func (c *Cache) LockPartial(url string) (func(), error) {
+	path, err := c.PartialPath(url)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := path + ".lock"
+
+	for attempt := 0; attempt < 2; attempt++ {
+		lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			lock.Close()
+			released := false
+			return func() {
+				if !released {
+					released = true
+					os.Remove(lockPath)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) || !staleLock(lockPath, path) {
+			break
+		}
+		os.Remove(lockPath)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBusy, url)
+}
+
+// staleLock reports whether neither the lock nor the partial download it
+// guards changed for activeGrace
+func staleLock(lockPath, path string) bool {
+	for _, p := range []string{lockPath, path} {
+		if info, err := os.Stat(p); err == nil && time.Since(info.ModTime()) < activeGrace {
+			return false
+		}
+	}
+	return true
+}
+
+// urlKey names the files kept for url
+func urlKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the entry of url, or ErrNotFound when its content isn't
+// cached
+func (c *Cache) Lookup(url string) (Entry, error) {
+	entry, err := readEntry(c.indexPath(url))
+	if err != nil || entry.URL != url {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, url)
+	}
+	info, err := os.Stat(c.blobPath(entry.SHA256))
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, url)
+	}
+	entry.Used = info.ModTime()
+	return entry, nil
+}
+
+// Has reports whether content with the SHA-256 hex digest sum is cached
+func (c *Cache) Has(sum string) bool {
+	if !validSum(sum) {
+		return false
+	}
+	_, err := os.Stat(c.blobPath(sum))
+	return err == nil
+}
+
+// CopyTo places the content with the SHA-256 hex digest sum at dst, linking
+// it when possible, and marks it as used
+func (c *Cache) CopyTo(sum, dst string) error {
+	blob, err := c.use(sum)
+	if err != nil {
+		return err
+	}
+	// Nothing writes to the files taken from the cache, so they can share
+	// the blob's data
+	if err := os.Link(blob, dst); err == nil {
+		return nil
+	}
+	return copyFile(blob, dst)
+}
+
+// ReadFile returns the content with the SHA-256 hex digest sum and marks it
+// as used
+func (c *Cache) ReadFile(sum string) ([]byte, error) {
+	blob, err := c.use(sum)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(blob)
+}
+
+// use returns the path of the blob with digest sum, recording its use in
+// its modification time for Prune
+func (c *Cache) use(sum string) (string, error) {
+	if !c.Has(sum) {
+		return "", fmt.Errorf("%w: sha256:%s", ErrNotFound, sum)
+	}
+	blob := c.blobPath(sum)
+	now := time.Now()
+	os.Chtimes(blob, now, now)
+	return blob, nil
+}
+
+// TempFile creates an empty file next to the blobs, for content that is
+// then moved in place with Store. Prune removes the ones left behind.
+func (c *Cache) TempFile() (string, error) {
+	for _, dir := range []string{"blobs", "index"} {
+		if err := os.MkdirAll(filepath.Join(c.dir, "downloads", dir), 0755); err != nil {
+			return "", err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "downloads", "blobs"), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// Put stores the content read from r as the content of entry.URL. The
+// digest, size and times of the entry are filled in from the content.
+func (c *Cache) Put(entry Entry, r io.Reader) (Entry, error) {
+	// Write a copy, then move it in place so that readers never see a
+	// partial blob
+	tmp, err := c.TempFile()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer os.Remove(tmp)
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return Entry{}, err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return c.Store(entry, tmp)
+}
+
+// Store moves the file at path, made with TempFile, into the cache as the
+// content of entry.URL. The digest, size and times of the entry are filled
+// in from the content.
+func (c *Cache) Store(entry Entry, path string) (Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	file.Close()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	entry.Size = size
+	entry.Stored = time.Now().UTC().Truncate(time.Second)
+	entry.Used = entry.Stored
+	if err := os.Rename(path, c.blobPath(entry.SHA256)); err != nil {
+		return Entry{}, err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := writeFileAtomic(c.indexPath(entry.URL), data); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// List returns the cached entries and the partial downloads, the most
+// recently used first
+func (c *Cache) List() ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "downloads", "index", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, path := range paths {
+		entry, err := readEntry(path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(c.blobPath(entry.SHA256))
+		if err != nil {
+			continue
+		}
+		entry.Used = info.ModTime()
+		entries = append(entries, entry)
+	}
+	entries = append(entries, c.partials()...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Used.After(entries[j].Used)
+	})
+	return entries, nil
+}
+
+// partials returns the interrupted downloads, dated by their last write
+func (c *Cache) partials() []Entry {
+	files, _ := os.ReadDir(c.partialDir())
+
+	var entries []Entry
+	for _, file := range files {
+		if ext := filepath.Ext(file.Name()); ext == ".json" || ext == ".lock" {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(c.partialDir(), file.Name())
+		entry := Entry{Size: info.Size(), Used: info.ModTime(), Partial: true, path: path}
+		// Downloads that can't be resumed have no state
+		if data, err := os.ReadFile(path + ".json"); err == nil {
+			var state struct {
+				URL string `json:"url"`
+			}
+			if json.Unmarshal(data, &state) == nil {
+				entry.URL = state.URL
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// PruneOptions selects what Prune removes. The zero value removes
+// nothing.
+type PruneOptions struct {
+	// All removes everything, whatever the other options
+	All bool
+	// MaxSize keeps the most recently used content up to this many bytes
+	MaxSize int64
+	// OlderThan removes the content not used for this long
+	OlderThan time.Duration
+}
+
+// Prune removes cached content and partial downloads, the least recently
+// used first, and returns the entries removed and the bytes freed. Content
+// shared by several URLs is removed with all of them. Partial downloads and
+// unfinished blobs written to within the last minutes may still be in
+// progress and are kept.
+func (c *Cache) Prune(opts PruneOptions) ([]Entry, int64, error) {
+	all := opts.All
+	if !all && opts.MaxSize <= 0 && opts.OlderThan <= 0 {
+		return nil, 0, nil
+	}
+	entries, err := c.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Blobs are kept or removed as a whole, by their most recent use
+	keep := make(map[string]bool)
+	var kept int64
+	for _, entry := range entries {
+		key := entry.key()
+		if _, seen := keep[key]; seen {
+			continue
+		}
+		fits := opts.MaxSize <= 0 || kept+entry.Size <= opts.MaxSize
+		recent := opts.OlderThan <= 0 || time.Since(entry.Used) < opts.OlderThan
+		active := entry.Partial && time.Since(entry.Used) < activeGrace
+		keep[key] = active || (!all && fits && recent)
+		if keep[key] {
+			kept += entry.Size
+		}
+	}
+
+	var removed []Entry
+	var freed int64
+	for _, entry := range entries {
+		if keep[entry.key()] {
+			continue
+		}
+		if entry.Partial {
+			if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+				return removed, freed, err
+			}
+			os.Remove(entry.path + ".json")
+			os.Remove(entry.path + ".lock")
+			removed = append(removed, entry)
+			freed += entry.Size
+			continue
+		}
+
+		if err := os.Remove(c.indexPath(entry.URL)); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		removed = append(removed, entry)
+		err := os.Remove(c.blobPath(entry.SHA256))
+		if err == nil {
+			freed += entry.Size
+		} else if !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+	}
+
+	// Blobs no entry refers to anymore, and leftovers of interrupted
+	// writes, are never used again. Recent ones may be stored by another
+	// take, which writes the blob before its entry.
+	blobs, _ := os.ReadDir(filepath.Join(c.dir, "downloads", "blobs"))
+	for _, blob := range blobs {
+		name := blob.Name()
+		if keep[name] {
+			continue
+		}
+		info, err := blob.Info()
+		if err != nil || time.Since(info.ModTime()) < activeGrace {
+			continue
+		}
+		if os.Remove(c.blobPath(name)) == nil {
+			freed += info.Size()
+		}
+	}
+	return removed, freed, nil
+}
+
+// key identifies what Prune keeps or removes as a whole: the content of
+// an entry, or a partial download
+func (e Entry) key() string {
+	if e.Partial {
+		return e.path
+	}
+	return e.SHA256
+}
+
+func readEntry(path string) (Entry, error) {
+	var entry Entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, err
+	}
+	if !validSum(entry.SHA256) {
+		return Entry{}, fmt.Errorf("invalid cache entry %s", path)
+	}
+	return entry, nil
+}
+
+// validSum reports whether sum is a lowercase SHA-256 hex digest, which
+// keeps blob paths inside the cache
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 || strings.ToLower(sum) != sum {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
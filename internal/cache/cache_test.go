@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutLookup(t *testing.T) {
+	c := New(t.TempDir())
+
+	if _, err := c.Lookup("https://example.com/a.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup() on an empty cache error = %v, want %v", err, ErrNotFound)
+	}
+
+	stored, err := c.Put(Entry{URL: "https://example.com/a.tar.gz", ETag: `"v1"`}, strings.NewReader("release"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte("release"))
+	if stored.SHA256 != hex.EncodeToString(sum[:]) || stored.Size != 7 {
+		t.Errorf("Put() = %+v, want the digest and size of the content", stored)
+	}
+
+	got, err := c.Lookup("https://example.com/a.tar.gz")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.SHA256 != stored.SHA256 || got.ETag != `"v1"` || got.Size != 7 {
+		t.Errorf("Lookup() = %+v, want %+v", got, stored)
+	}
+	if !c.Has(got.SHA256) {
+		t.Errorf("Has(%s) = false after Put()", got.SHA256)
+	}
+
+	// The same content from another URL is stored once
+	if _, err := c.Put(Entry{URL: "https://mirror.example.com/a.tar.gz"}, strings.NewReader("release")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	blobs, _ := os.ReadDir(filepath.Join(c.Dir(), "downloads", "blobs"))
+	if len(blobs) != 1 {
+		t.Errorf("cache holds %d blobs, want 1", len(blobs))
+	}
+
+	dst := filepath.Join(t.TempDir(), "a.tar.gz")
+	if err := c.CopyTo(got.SHA256, dst); err != nil {
+		t.Fatalf("CopyTo() error = %v", err)
+	}
+	if content, err := os.ReadFile(dst); err != nil || string(content) != "release" {
+		t.Errorf("CopyTo() wrote %q (%v), want %q", content, err, "release")
+	}
+	if content, err := c.ReadFile(got.SHA256); err != nil || string(content) != "release" {
+		t.Errorf("ReadFile() = %q (%v), want %q", content, err, "release")
+	}
+
+	for _, sum := range []string{strings.Repeat("0", 64), "../../etc/passwd", strings.ToUpper(got.SHA256)} {
+		if err := c.CopyTo(sum, dst+"-"+sum[:2]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("CopyTo(%q) error = %v, want %v", sum, err, ErrNotFound)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	c := New(t.TempDir())
+
+	tmp, err := c.TempFile()
+	if err != nil {
+		t.Fatalf("TempFile() error = %v", err)
+	}
+	if err := os.WriteFile(tmp, []byte("release"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := c.Store(Entry{URL: "https://example.com/a.tar.gz"}, tmp)
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("Store() left the file in place, want it moved into the cache")
+	}
+	if got, err := c.Lookup("https://example.com/a.tar.gz"); err != nil || got.SHA256 != stored.SHA256 || got.Size != 7 {
+		t.Errorf("Lookup() = %+v (%v), want %+v", got, err, stored)
+	}
+}
+
+func TestLockPartial(t *testing.T) {
+	c := New(t.TempDir())
+	const url = "https://example.com/a.tar.gz"
+
+	unlock, err := c.LockPartial(url)
+	if err != nil {
+		t.Fatalf("LockPartial() error = %v", err)
+	}
+	if _, err := c.LockPartial(url); !errors.Is(err, ErrBusy) {
+		t.Errorf("LockPartial() while locked error = %v, want %v", err, ErrBusy)
+	}
+	if unlockOther, err := c.LockPartial("https://example.com/b.tar.gz"); err != nil {
+		t.Errorf("LockPartial() of another URL error = %v", err)
+	} else {
+		unlockOther()
+	}
+
+	unlock()
+	unlock, err = c.LockPartial(url)
+	if err != nil {
+		t.Fatalf("LockPartial() after unlock error = %v", err)
+	}
+	if entries, _ := c.List(); len(entries) != 0 {
+		t.Errorf("List() = %+v, want locks left out", entries)
+	}
+
+	// The lock of a take that crashed is taken over once it is stale
+	path, _ := c.PartialPath(url)
+	old := time.Now().Add(-2 * activeGrace)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LockPartial(url); err != nil {
+		t.Errorf("LockPartial() over a stale lock error = %v", err)
+	}
+}
+
+func TestPrunePartials(t *testing.T) {
+	c := New(t.TempDir())
+	now := time.Now()
+	partials := []struct {
+		url string
+		age time.Duration
+	}{
+		{"https://example.com/active.tar.gz", time.Minute},
+		{"https://example.com/stale.tar.gz", 48 * time.Hour},
+	}
+	for _, p := range partials {
+		path, err := c.PartialPath(p.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(path, []byte("partial"), 0644)
+		os.WriteFile(path+".json", []byte(`{"url":"`+p.url+`"}`), 0644)
+		used := now.Add(-p.age)
+		os.Chtimes(path, used, used)
+	}
+
+	entries, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].Partial || entries[0].URL != partials[0].url || entries[1].URL != partials[1].url {
+		t.Fatalf("List() = %+v, want both partial downloads", entries)
+	}
+
+	// Emptying the cache leaves the download that may still be running
+	removed, freed, err := c.Prune(PruneOptions{All: true})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if len(removed) != 1 || removed[0].URL != partials[1].url || freed != 7 {
+		t.Errorf("Prune() removed %+v, freeing %d bytes, want the stale download", removed, freed)
+	}
+	stale, _ := c.PartialPath(partials[1].url)
+	for _, path := range []string{stale, stale + ".json"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Prune() left %s", filepath.Base(path))
+		}
+	}
+	active, _ := c.PartialPath(partials[0].url)
+	if _, err := os.Stat(active + ".json"); err != nil {
+		t.Errorf("Prune() removed the active download: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	// Content of each size, last used that many hours ago
+	files := []struct {
+		url  string
+		size int
+		age  time.Duration
+	}{
+		{"https://example.com/new.tar.gz", 10, 0},
+		{"https://example.com/mid.tar.gz", 20, 2 * time.Hour},
+		{"https://example.com/old.tar.gz", 30, 48 * time.Hour},
+	}
+
+	tests := []struct {
+		name      string
+		opts      PruneOptions
+		wantKept  []string
+		wantFreed int64
+	}{
+		{
+			name: "nothing",
+			wantKept: []string{
+				"https://example.com/new.tar.gz",
+				"https://example.com/mid.tar.gz",
+				"https://example.com/old.tar.gz",
+			},
+		},
+		{
+			name:      "everything",
+			opts:      PruneOptions{All: true},
+			wantFreed: 60,
+		},
+		{
+			name:      "max size",
+			opts:      PruneOptions{MaxSize: 35},
+			wantKept:  []string{"https://example.com/new.tar.gz", "https://example.com/mid.tar.gz"},
+			wantFreed: 30,
+		},
+		{
+			name:      "older than",
+			opts:      PruneOptions{OlderThan: time.Hour},
+			wantKept:  []string{"https://example.com/new.tar.gz"},
+			wantFreed: 50,
+		},
+		{
+			name:      "both",
+			opts:      PruneOptions{MaxSize: 5, OlderThan: 24 * time.Hour},
+			wantFreed: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(t.TempDir())
+			for _, f := range files {
+				entry, err := c.Put(Entry{URL: f.url}, strings.NewReader(strings.Repeat(f.url[20:21], f.size)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				used := now.Add(-f.age)
+				if err := os.Chtimes(c.blobPath(entry.SHA256), used, used); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A leftover of an interrupted write, and a blob being written
+			blobs := filepath.Join(c.Dir(), "downloads", "blobs")
+			os.WriteFile(filepath.Join(blobs, ".tmp-1"), nil, 0644)
+			os.Chtimes(filepath.Join(blobs, ".tmp-1"), now.Add(-time.Hour), now.Add(-time.Hour))
+			os.WriteFile(filepath.Join(blobs, ".tmp-2"), nil, 0644)
+
+			_, freed, err := c.Prune(tt.opts)
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+			if freed != tt.wantFreed {
+				t.Errorf("Prune() freed %d bytes, want %d", freed, tt.wantFreed)
+			}
+
+			entries, err := c.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, entry := range entries {
+				kept = append(kept, entry.URL)
+			}
+			if strings.Join(kept, " ") != strings.Join(tt.wantKept, " ") {
+				t.Errorf("kept %v, want %v", kept, tt.wantKept)
+			}
+			_, err = os.Stat(filepath.Join(blobs, ".tmp-1"))
+			if tt.opts != (PruneOptions{}) && !os.IsNotExist(err) {
+				t.Error("Prune() left an unfinished blob")
+			}
+			if _, err := os.Stat(filepath.Join(blobs, ".tmp-2")); err != nil {
+				t.Errorf("Prune() removed a blob being written: %v", err)
+			}
+		})
+	}
+}
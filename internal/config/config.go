@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deblasis/take/internal/git"
+	"github.com/deblasis/take/internal/httpclient"
+	"github.com/deblasis/take/internal/retry"
+)
+
+// Config represents the user's take configuration, read from a JSON file
+type Config struct {
+	// CloneRoot organizes clones as <root>/<host>/<owner>/<repo>
+	CloneRoot string `json:"clone_root,omitempty"`
+	// Forges add or override shorthand prefixes such as gh:owner/repo
+	Forges []git.Forge `json:"forges,omitempty"`
+	// DefaultForge is the prefix bare owner/repo specs expand with
+	DefaultForge string `json:"default_forge,omitempty"`
+	// Protocol is the preferred protocol for shorthands, "https" or "ssh"
+	Protocol string `json:"protocol,omitempty"`
+	// CacheDir is where take caches downloads and keeps interrupted ones to
+	// resume
+	CacheDir string `json:"cache_dir,omitempty"`
+	// Retry is how downloads and clones failing on network trouble are
+	// retried
+	Retry retry.Policy `json:"retry"`
+	// HTTP configures the client downloads are made with: certificate
+	// authorities, proxy, headers and credentials
+	HTTP httpclient.Config `json:"http"`
+	// TrustedKeys are the minisign or SSH public keys, or files of them,
+	// archive signatures are trusted from
+	TrustedKeys []string `json:"trusted_keys,omitempty"`
+}
+
+// Path returns the location of the config file. TAKE_CONFIG overrides the
+// default of take/config.json in the user's config directory.
+func Path() (string, error) {
+	if path := os.Getenv("TAKE_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "take", "config.json"), nil
+}
+
+// Load reads the config file. A missing file yields an empty config.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return &Config{}, nil
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads the config file at path. A missing file yields an empty
+// config.
+func LoadFile(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deblasis/take/internal/git"
+	"github.com/deblasis/take/internal/httpclient"
+	"github.com/deblasis/take/internal/retry"
+)
+
+func TestLoadFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "missing file",
+		},
+		{
+			name:    "clone root",
+			content: `{"clone_root": "~/src"}`,
+			want:    Config{CloneRoot: "~/src"},
+		},
+		{
+			name:    "cache dir",
+			content: `{"cache_dir": "/var/cache/take"}`,
+			want:    Config{CacheDir: "/var/cache/take"},
+		},
+		{
+			name:    "retry policy",
+			content: `{"retry": {"attempts": 5, "backoff": "500ms", "max_backoff": "1m", "jitter": 0.5, "status_codes": [429, 503]}}`,
+			want: Config{Retry: retry.Policy{
+				Attempts:    5,
+				Backoff:     500 * time.Millisecond,
+				MaxBackoff:  time.Minute,
+				Jitter:      0.5,
+				StatusCodes: []int{429, 503},
+			}},
+		},
+		{
+			name: "http settings",
+			content: `{"http": {
+				"ca_file": "~/certs/corp.pem",
+				"proxy": "http://proxy.example.com:3128",
+				"headers": {"X-Team": "platform"},
+				"user_agent": "take-ci",
+				"hosts": [{"host": "*.corp.example.com", "headers": {"Authorization": "Bearer abc"}}],
+				"netrc": "~/.netrc-take"
+			}}`,
+			want: Config{HTTP: httpclient.Config{
+				CAFile:    "~/certs/corp.pem",
+				Proxy:     "http://proxy.example.com:3128",
+				Headers:   map[string]string{"X-Team": "platform"},
+				UserAgent: "take-ci",
+				Hosts: []httpclient.HostHeaders{
+					{Host: "*.corp.example.com", Headers: map[string]string{"Authorization": "Bearer abc"}},
+				},
+				Netrc: "~/.netrc-take",
+			}},
+		},
+		{
+			name:    "trusted keys",
+			content: `{"trusted_keys": ["~/.config/take/release.pub", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAl4kSIQHx9o4pssbpJzJzJohIlquwCE38sFcxWJjvbJ"]}`,
+			want: Config{TrustedKeys: []string{
+				"~/.config/take/release.pub",
+				"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAl4kSIQHx9o4pssbpJzJzJohIlquwCE38sFcxWJjvbJ",
+			}},
+		},
+		{
+			name:    "invalid retry backoff",
+			content: `{"retry": {"backoff": "soon"}}`,
+			wantErr: true,
+		},
+		{
+			name: "forges",
+			content: `{
+				"forges": [{"prefix": "tea", "host": "git.example.com", "ssh_port": 2222}],
+				"default_forge": "gh",
+				"protocol": "ssh"
+			}`,
+			want: Config{
+				Forges:       []git.Forge{{Prefix: "tea", Host: "git.example.com", SSHPort: 2222}},
+				DefaultForge: "gh",
+				Protocol:     "ssh",
+			},
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"clone_root": `,
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, fmt.Sprintf("config-%d.json", i))
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatalf("Failed to write config: %v", err)
+				}
+			}
+
+			got, err := LoadFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("LoadFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPath(t *testing.T) {
+	orig, had := os.LookupEnv("TAKE_CONFIG")
+	defer func() {
+		if had {
+			os.Setenv("TAKE_CONFIG", orig)
+		} else {
+			os.Unsetenv("TAKE_CONFIG")
+		}
+	}()
+
+	os.Setenv("TAKE_CONFIG", "/custom/config.json")
+	got, err := Path()
+	if err != nil {
+		t.Fatalf("Path() error = %v", err)
+	}
+	if got != "/custom/config.json" {
+		t.Errorf("Path() = %v, want %v", got, "/custom/config.json")
+	}
+}
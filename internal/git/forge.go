@@ -0,0 +1,303 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Protocol selects the clone URL form shorthand specs expand to
+type Protocol string
+
+const (
+	ProtocolHTTPS Protocol = "https"
+	ProtocolSSH   Protocol = "ssh"
+)
+
+// ForgeType identifies the web UI of a forge, which determines how its
+// browser URLs map to repositories, refs and subdirectories
+type ForgeType string
+
+const (
+	ForgeGitHub    ForgeType = "github"
+	ForgeGitLab    ForgeType = "gitlab"
+	ForgeGitea     ForgeType = "gitea"
+	ForgeBitbucket ForgeType = "bitbucket"
+	ForgeSourceHut ForgeType = "sourcehut"
+)
+
+// Forge maps a shorthand prefix to a git host
+type Forge struct {
+	// Prefix is the shorthand prefix, e.g. "gh" for gh:owner/repo
+	Prefix string `json:"prefix"`
+	// Host is the forge host name, e.g. github.com
+	Host string `json:"host"`
+	// Type is the forge software, used to understand browser URLs such as
+	// https://github.com/owner/repo/tree/main/docs. Gitea also covers
+	// Forgejo.
+	Type ForgeType `json:"type,omitempty"`
+	// Protocol overrides the preferred protocol for this forge
+	Protocol Protocol `json:"protocol,omitempty"`
+	// SSHUser is the user of SSH clone URLs, "git" when empty
+	SSHUser string `json:"ssh_user,omitempty"`
+	// SSHPort is the port of SSH clone URLs, 22 when zero
+	SSHPort int `json:"ssh_port,omitempty"`
+}
+
+// DefaultForges are the built-in shorthand prefixes
+var DefaultForges = []Forge{
+	{Prefix: "gh", Host: "github.com", Type: ForgeGitHub},
+	{Prefix: "gl", Host: "gitlab.com", Type: ForgeGitLab},
+	{Prefix: "cb", Host: "codeberg.org", Type: ForgeGitea},
+	{Prefix: "bb", Host: "bitbucket.org", Type: ForgeBitbucket},
+	{Prefix: "sr", Host: "git.sr.ht", Type: ForgeSourceHut},
+}
+
+// Shorthands expands repository specs such as gh:owner/repo to clone URLs
+type Shorthands struct {
+	// Forges are searched in order, so entries placed before
+	// DefaultForges override them
+	Forges []Forge
+	// Default is the prefix bare owner/repo specs expand with. Bare specs
+	// are not expanded when it is empty.
+	Default string
+	// Protocol is the preferred clone protocol, HTTPS when empty
+	Protocol Protocol
+}
+
+// shorthandPath matches the owner/repo part of a spec. GitLab allows nested
+// groups, so more than two segments are accepted.
+var shorthandPath = regexp.MustCompile(`^[A-Za-z0-9_.~-]+(/[A-Za-z0-9_.-]+)+$`)
+
+// Expand returns the clone URL for a shorthand spec, or false if spec is
+// not a shorthand
+func (s Shorthands) Expand(spec string) (string, bool) {
+	prefix, path := s.Default, spec
+	if i := strings.Index(spec, ":"); i > 0 {
+		prefix, path = spec[:i], spec[i+1:]
+	}
+
+	if prefix == "" || !shorthandPath.MatchString(path) {
+		return "", false
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "." || part == ".." {
+			return "", false
+		}
+	}
+
+	forge, ok := s.forge(prefix)
+	if !ok {
+		return "", false
+	}
+
+	path = strings.TrimSuffix(path, ".git")
+	protocol := forge.Protocol
+	if protocol == "" {
+		protocol = s.Protocol
+	}
+
+	if protocol == ProtocolSSH {
+		user := forge.SSHUser
+		if user == "" {
+			user = "git"
+		}
+		if forge.SSHPort != 0 && forge.SSHPort != 22 {
+			return fmt.Sprintf("ssh://%s@%s:%d/%s.git", user, forge.Host, forge.SSHPort, path), true
+		}
+		return fmt.Sprintf("%s@%s:%s.git", user, forge.Host, path), true
+	}
+	return fmt.Sprintf("https://%s/%s.git", forge.Host, path), true
+}
+
+// forge looks up the forge registered for prefix
+func (s Shorthands) forge(prefix string) (Forge, bool) {
+	for _, forge := range s.Forges {
+		if forge.Prefix == prefix {
+			return forge, true
+		}
+	}
+	return Forge{}, false
+}
+
+// Location is a repository, and optionally a ref and a directory inside it,
+// parsed from a browser URL
+type Location struct {
+	// URL is the clone URL of the repository
+	URL string
+	// Ref is the branch or tag named in the URL
+	Ref string
+	// Subdir is the slash-separated path inside the repository
+	Subdir string
+	// Known is set when URL was already found to serve a repository, so
+	// cloning it needs no probe
+	Known bool
+}
+
+// ParseWebURL recognizes the browser URL of a repository on one of the
+// given forges, with or without a .git suffix, including "tree" URLs that
+// name a branch and a subdirectory. Since branch names may contain slashes,
+// the remote's refs are listed to split the tree path when it is ambiguous,
+// unless offline, which takes its first segment as the ref.
+func ParseWebURL(ctx context.Context, rawURL string, forges []Forge, offline bool) (Location, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.RawQuery != "" {
+		return Location{}, false
+	}
+
+	var forge Forge
+	found := false
+	for _, f := range forges {
+		if strings.EqualFold(f.Host, u.Host) {
+			forge, found = f, true
+			break
+		}
+	}
+	if !found {
+		return Location{}, false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	repo, tree, ok := splitWebPath(forge.Type, segments)
+	if !ok || len(repo) < 2 {
+		return Location{}, false
+	}
+	for _, parts := range [][]string{repo, tree} {
+		for _, part := range parts {
+			if part == "" || part == "." || part == ".." {
+				return Location{}, false
+			}
+		}
+	}
+
+	repoPath := strings.TrimSuffix(strings.Join(repo, "/"), ".git")
+	loc := Location{URL: fmt.Sprintf("%s://%s/%s.git", u.Scheme, u.Host, repoPath)}
+	if len(tree) > 0 {
+		loc.Ref, loc.Subdir = splitTreePath(ctx, loc.URL, tree, offline)
+	}
+	return loc, true
+}
+
+// splitWebPath splits the path segments of a browser URL into the
+// repository path and the tree path (ref followed by subdirectory)
+func splitWebPath(forgeType ForgeType, segments []string) (repo, tree []string, ok bool) {
+	switch forgeType {
+	case ForgeGitLab:
+		// /group/subgroup/repo/-/tree/<ref>/<path>
+		for i, s := range segments {
+			if s == "-" {
+				return routeTree(segments[:i], segments[i+1:], "tree")
+			}
+		}
+		return segments, nil, true
+	case ForgeGitea:
+		// /owner/repo/src/branch/<ref>/<path>, also src/tag/<ref>
+		if len(segments) > 3 && segments[2] == "src" && (segments[3] == "branch" || segments[3] == "tag") {
+			return segments[:2], segments[4:], len(segments) > 4
+		}
+	case ForgeBitbucket:
+		// /owner/repo/src/<ref>/<path>
+		if len(segments) > 2 {
+			return routeTree(segments[:2], segments[2:], "src")
+		}
+	case ForgeSourceHut:
+		// /~owner/repo/tree/<ref>/item/<path>
+		if len(segments) > 4 && segments[2] == "tree" && segments[4] == "item" {
+			return segments[:2], append([]string{segments[3]}, segments[5:]...), true
+		}
+	}
+
+	if len(segments) <= 2 {
+		return segments, nil, true
+	}
+	// /owner/repo/tree/<ref>/<path> (GitHub and forges without a type)
+	return routeTree(segments[:2], segments[2:], "tree")
+}
+
+// routeTree accepts rest as a tree path when it starts with the given route
+func routeTree(repo, rest []string, route string) ([]string, []string, bool) {
+	if len(rest) == 0 {
+		return repo, nil, true
+	}
+	if len(rest) < 2 || rest[0] != route {
+		return nil, nil, false
+	}
+	return repo, rest[1:], true
+}
+
+// splitTreePath splits a tree path into a ref and a subdirectory, matching
+// the longest prefix that is a branch or tag of the remote. If the refs
+// can't be listed, or offline, the first segment is taken as the ref.
+func splitTreePath(ctx context.Context, cloneURL string, tree []string, offline bool) (string, string) {
+	if len(tree) > 1 && !offline {
+		if refs, err := listRefs(ctx, cloneURL); err == nil {
+			for i := len(tree); i > 0; i-- {
+				ref := strings.Join(tree[:i], "/")
+				if refs[ref] {
+					return ref, strings.Join(tree[i:], "/")
+				}
+			}
+		}
+	}
+	return tree[0], strings.Join(tree[1:], "/")
+}
+
+// ArchiveURL returns the URL of the tar.gz snapshot a forge serves for the
+// repository at loc.Ref, which is cheaper to download than a clone. Without a
+// ref only forges that resolve HEAD to the default branch are supported.
+func ArchiveURL(loc Location, forges []Forge) (string, bool) {
+	repoPath, ok := RepoPath(loc.URL)
+	if !ok {
+		return "", false
+	}
+	host, path, _ := strings.Cut(repoPath, "/")
+
+	// Web URLs keep their scheme and port, SSH URLs map to HTTPS. Forges
+	// are configured by host name, or by host and port.
+	scheme, webHost := "https", ""
+	if u, err := url.Parse(loc.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		scheme, host, webHost = u.Scheme, u.Hostname(), u.Host
+	}
+
+	var forge Forge
+	found := false
+	for _, f := range forges {
+		if strings.EqualFold(f.Host, host) || (webHost != "" && strings.EqualFold(f.Host, webHost)) {
+			forge, found = f, true
+			break
+		}
+	}
+	if !found {
+		return "", false
+	}
+	if webHost == "" {
+		webHost = forge.Host
+	}
+
+	ref := loc.Ref
+	if ref == "" {
+		switch forge.Type {
+		case ForgeGitHub, ForgeGitLab, ForgeBitbucket:
+			ref = "HEAD"
+		default:
+			return "", false
+		}
+	}
+
+	base := fmt.Sprintf("%s://%s/%s", scheme, webHost, path)
+
+	switch forge.Type {
+	case ForgeGitHub:
+		return fmt.Sprintf("%s/archive/%s.tar.gz", base, ref), true
+	case ForgeGitLab:
+		name := path[strings.LastIndex(path, "/")+1:]
+		return fmt.Sprintf("%s/-/archive/%s/%s-%s.tar.gz", base, ref, name, strings.ReplaceAll(ref, "/", "-")), true
+	case ForgeGitea, ForgeSourceHut:
+		return fmt.Sprintf("%s/archive/%s.tar.gz", base, ref), true
+	case ForgeBitbucket:
+		return fmt.Sprintf("%s/get/%s.tar.gz", base, ref), true
+	}
+	return "", false
+}
@@ -0,0 +1,269 @@
+package git
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShorthandsExpand(t *testing.T) {
+	custom := Shorthands{
+		Forges: append([]Forge{
+			{Prefix: "tea", Host: "git.example.com", Protocol: ProtocolSSH, SSHPort: 2222},
+			{Prefix: "gh", Host: "github.example.com"},
+		}, DefaultForges...),
+		Default: "gl",
+	}
+
+	tests := []struct {
+		name       string
+		shorthands Shorthands
+		spec       string
+		want       string
+		wantOK     bool
+	}{
+		{
+			name:       "GitHub over HTTPS",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "gh:user/repo",
+			want:       "https://github.com/user/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "GitHub over SSH",
+			shorthands: Shorthands{Forges: DefaultForges, Protocol: ProtocolSSH},
+			spec:       "gh:user/repo",
+			want:       "git@github.com:user/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "GitLab nested groups",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "gl:group/subgroup/repo.git",
+			want:       "https://gitlab.com/group/subgroup/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "Codeberg",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "cb:user/repo",
+			want:       "https://codeberg.org/user/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "self-hosted Gitea with SSH port",
+			shorthands: custom,
+			spec:       "tea:team/repo",
+			want:       "ssh://git@git.example.com:2222/team/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "custom forge overrides default",
+			shorthands: custom,
+			spec:       "gh:user/repo",
+			want:       "https://github.example.com/user/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "bare spec with default forge",
+			shorthands: custom,
+			spec:       "user/repo",
+			want:       "https://gitlab.com/user/repo.git",
+			wantOK:     true,
+		},
+		{
+			name:       "bare spec without default forge",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "user/repo",
+		},
+		{
+			name:       "unknown prefix",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "xx:user/repo",
+		},
+		{
+			name:       "missing repository",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "gh:user",
+		},
+		{
+			name:       "path traversal",
+			shorthands: Shorthands{Forges: DefaultForges},
+			spec:       "gh:user/../repo",
+		},
+		{
+			name:       "SSH URL",
+			shorthands: custom,
+			spec:       "git@github.com:user/repo.git",
+		},
+		{
+			name:       "HTTPS URL",
+			shorthands: custom,
+			spec:       "https://github.com/user/repo.git",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.shorthands.Expand(tt.spec)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Expand(%q) = %q, %v, want %q, %v", tt.spec, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseWebURL(t *testing.T) {
+	forges := append([]Forge{
+		{Prefix: "tea", Host: "git.example.com", Type: ForgeGitea},
+	}, DefaultForges...)
+
+	tests := []struct {
+		name   string
+		url    string
+		want   Location
+		wantOK bool
+	}{
+		{
+			name:   "GitHub repository",
+			url:    "https://github.com/user/repo",
+			want:   Location{URL: "https://github.com/user/repo.git"},
+			wantOK: true,
+		},
+		{
+			name:   "GitHub tree URL",
+			url:    "https://github.com/user/repo/tree/main",
+			want:   Location{URL: "https://github.com/user/repo.git", Ref: "main"},
+			wantOK: true,
+		},
+		{
+			name:   "GitLab nested group tree URL",
+			url:    "https://gitlab.com/group/sub/repo/-/tree/v1.0",
+			want:   Location{URL: "https://gitlab.com/group/sub/repo.git", Ref: "v1.0"},
+			wantOK: true,
+		},
+		{
+			name:   "Gitea tree URL",
+			url:    "https://git.example.com/team/repo/src/branch/develop",
+			want:   Location{URL: "https://git.example.com/team/repo.git", Ref: "develop"},
+			wantOK: true,
+		},
+		{
+			name:   "Codeberg repository with trailing slash",
+			url:    "https://codeberg.org/user/repo/",
+			want:   Location{URL: "https://codeberg.org/user/repo.git"},
+			wantOK: true,
+		},
+		{
+			name:   "Bitbucket source URL",
+			url:    "https://bitbucket.org/team/repo/src/master",
+			want:   Location{URL: "https://bitbucket.org/team/repo.git", Ref: "master"},
+			wantOK: true,
+		},
+		{
+			name:   "SourceHut tree URL",
+			url:    "https://git.sr.ht/~user/repo/tree/main/item/docs",
+			want:   Location{URL: "https://git.sr.ht/~user/repo.git", Ref: "main", Subdir: "docs"},
+			wantOK: true,
+		},
+		{
+			name: "GitHub issues page",
+			url:  "https://github.com/user/repo/issues",
+		},
+		{
+			name: "GitHub user page",
+			url:  "https://github.com/user",
+		},
+		{
+			name: "unknown host",
+			url:  "https://example.com/user/repo",
+		},
+		{
+			name: "SSH URL",
+			url:  "git@github.com:user/repo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseWebURL(context.Background(), tt.url, forges, false)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ParseWebURL(%q) = %+v, %v, want %+v, %v", tt.url, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestArchiveURL(t *testing.T) {
+	forges := append([]Forge{{Prefix: "tea", Host: "git.example.com", Type: ForgeGitea}}, DefaultForges...)
+
+	tests := []struct {
+		name   string
+		loc    Location
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "GitHub default branch",
+			loc:    Location{URL: "https://github.com/user/repo.git"},
+			want:   "https://github.com/user/repo/archive/HEAD.tar.gz",
+			wantOK: true,
+		},
+		{
+			name:   "GitHub SSH URL with tag",
+			loc:    Location{URL: "git@github.com:user/repo.git", Ref: "v1.2.0"},
+			want:   "https://github.com/user/repo/archive/v1.2.0.tar.gz",
+			wantOK: true,
+		},
+		{
+			name:   "GitLab nested group with branch",
+			loc:    Location{URL: "https://gitlab.com/group/sub/repo.git", Ref: "feature/x"},
+			want:   "https://gitlab.com/group/sub/repo/-/archive/feature/x/repo-feature-x.tar.gz",
+			wantOK: true,
+		},
+		{
+			name:   "Bitbucket",
+			loc:    Location{URL: "https://bitbucket.org/user/repo.git"},
+			want:   "https://bitbucket.org/user/repo/get/HEAD.tar.gz",
+			wantOK: true,
+		},
+		{
+			name:   "Gitea with ref",
+			loc:    Location{URL: "https://git.example.com/user/repo.git", Ref: "main"},
+			want:   "https://git.example.com/user/repo/archive/main.tar.gz",
+			wantOK: true,
+		},
+		{
+			name:   "Gitea on another port",
+			loc:    Location{URL: "https://git.example.com:8443/user/repo.git", Ref: "main"},
+			want:   "https://git.example.com:8443/user/repo/archive/main.tar.gz",
+			wantOK: true,
+		},
+		{
+			name: "Gitea without ref",
+			loc:  Location{URL: "https://codeberg.org/user/repo.git"},
+		},
+		{
+			name:   "SourceHut with ref",
+			loc:    Location{URL: "https://git.sr.ht/~user/repo", Ref: "main"},
+			want:   "https://git.sr.ht/~user/repo/archive/main.tar.gz",
+			wantOK: true,
+		},
+		{
+			name: "unknown host",
+			loc:  Location{URL: "https://example.com/user/repo.git"},
+		},
+		{
+			name: "local repository",
+			loc:  Location{URL: "/tmp/repo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ArchiveURL(tt.loc, forges)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ArchiveURL() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -1,17 +1,37 @@
 package git
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/deblasis/take/internal/retry"
 )
 
+// probeTimeout bounds git ls-remote calls used to inspect remotes
+const probeTimeout = 30 * time.Second
+
+// cancelWaitDelay is how long an interrupted git command may take to exit
+// before it is killed
+const cancelWaitDelay = 5 * time.Second
+
 var (
-	ErrInvalidURL  = errors.New("invalid git URL")
-	ErrCloneFailed = errors.New("git clone failed")
+	ErrInvalidURL      = errors.New("invalid git URL")
+	ErrCloneFailed     = errors.New("git clone failed")
+	ErrPullFailed      = errors.New("git pull failed")
+	ErrCheckoutFailed  = errors.New("git checkout failed")
+	ErrRefNotLocal     = errors.New("ref not available locally")
+	ErrInvalidRef      = errors.New("invalid git ref")
+	ErrSubmoduleFailed = errors.New("git submodule update failed")
+	ErrInitFailed      = errors.New("git init failed")
 )
 
 // CloneOptions represents options for cloning a repository
@@ -19,54 +39,359 @@ type CloneOptions struct {
 	URL       string
 	TargetDir string
 	Depth     int
+	// Ref is the branch, tag or commit SHA to check out instead of the
+	// remote's default branch
+	Ref string
+	// Filter makes a partial clone that fetches objects on demand, e.g.
+	// blob:none or tree:0
+	Filter string
+	// Sparse limits the checkout to these directories with a cone mode
+	// sparse-checkout. Files at the top of the repository are always
+	// checked out.
+	Sparse []string
+	// Submodules initializes submodules recursively after checkout. With a
+	// Depth, submodules are cloned shallow too.
+	Submodules bool
+	// Retry is how a clone failing on network trouble is retried
+	Retry retry.Policy
+	// Known skips checking that URL serves a repository, for callers that
+	// already probed it
+	Known bool
 }
 
-// Clone clones a git repository
-func Clone(opts CloneOptions) error {
-	// For local repos, just copy the directory
-	if IsGitRepo(opts.URL) {
-		// Use git clone for local repos too to maintain git history
-		args := []string{"clone"}
-		if opts.Depth > 0 {
-			args = append(args, "--depth", fmt.Sprintf("%d", opts.Depth))
-		}
-		args = append(args, opts.URL, opts.TargetDir)
+// commitPattern matches full and abbreviated commit SHAs
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
 
-		cmd := exec.Command("git", args...)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("%w: %s", ErrCloneFailed, string(output))
+// Clone clones a git repository
+func Clone(ctx context.Context, opts CloneOptions) error {
+	if opts.Ref != "" {
+		if err := checkRef(ctx, opts.Ref); err != nil {
+			return err
 		}
-		return nil
 	}
 
-	if !IsValidURL(opts.URL) {
+	// Local repos are cloned with git too, to maintain git history. URLs
+	// without a .git suffix on unknown hosts are accepted if they serve a
+	// repository.
+	if !opts.Known && !IsValidURL(opts.URL) && !IsRemoteRepo(ctx, opts.URL) {
 		return ErrInvalidURL
 	}
 
+	targetDir := opts.TargetDir
+	if targetDir == "" {
+		targetDir = GetRepoName(opts.URL)
+	}
+
+	// Branches and tags can be cloned directly, commits are fetched and
+	// checked out once the clone exists
+	commit := opts.Ref != "" && isCommit(ctx, opts.URL, opts.Ref)
+
 	args := []string{"clone"}
 
 	if opts.Depth > 0 {
 		args = append(args, "--depth", fmt.Sprintf("%d", opts.Depth))
 	}
+	if opts.Filter != "" {
+		args = append(args, "--filter="+opts.Filter)
+	}
+	if len(opts.Sparse) > 0 {
+		args = append(args, "--sparse")
+	}
 
-	// Add target directory if specified
-	if opts.TargetDir != "" {
-		args = append(args, opts.URL, opts.TargetDir)
-	} else {
-		args = append(args, opts.URL)
+	switch {
+	case commit:
+		args = append(args, "--no-checkout")
+	case opts.Ref != "":
+		args = append(args, "--branch", opts.Ref)
 	}
 
-	cmd := exec.Command("git", args...)
-	output, err := cmd.CombinedOutput()
+	args = append(args, opts.URL, targetDir)
+
+	if output, err := runGitRetry(ctx, opts.Retry, "", args...); err != nil {
+		return commandError(ErrCloneFailed, output, err)
+	}
+
+	if len(opts.Sparse) > 0 {
+		if err := SparseCheckout(ctx, targetDir, opts.Sparse); err != nil {
+			return err
+		}
+	}
+
+	if commit {
+		if err := checkoutCommit(ctx, targetDir, opts.Ref, opts.Depth); err != nil {
+			return err
+		}
+	}
+
+	if opts.Submodules {
+		return UpdateSubmodules(ctx, targetDir, opts.Depth > 0)
+	}
+	return nil
+}
+
+// isCommit reports whether ref names a commit rather than a branch or tag
+// of the remote
+func isCommit(ctx context.Context, url, ref string) bool {
+	if !commitPattern.MatchString(ref) {
+		return false
+	}
+	refs, err := listRefs(ctx, url)
+	return err != nil || !refs[ref]
+}
+
+// checkoutCommit checks out a commit in a clone made with --no-checkout,
+// fetching it first when the clone doesn't contain it. Servers only hand
+// out commits by full SHA, so abbreviated SHAs need a full clone.
+func checkoutCommit(ctx context.Context, dir, sha string, depth int) error {
+	if depth == 0 {
+		if _, err := runGit(ctx, dir, "checkout", "--quiet", "--detach", sha); err == nil {
+			return nil
+		}
+	}
+
+	args := []string{"fetch", "--quiet"}
+	if depth > 0 {
+		args = append(args, "--depth", fmt.Sprintf("%d", depth))
+	}
+	args = append(args, "origin", sha)
+	if output, err := runGit(ctx, dir, args...); err != nil {
+		return commandError(fmt.Errorf("%w: commit %s", ErrCloneFailed, sha), output, err)
+	}
+
+	if output, err := runGit(ctx, dir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return commandError(fmt.Errorf("%w: commit %s", ErrCloneFailed, sha), output, err)
+	}
+	return nil
+}
+
+// Checkout switches the repository in dir to ref, which may be a branch,
+// tag or commit, fetching it from origin if it isn't known locally. Git
+// refuses to switch when local changes would be overwritten. Offline, a ref
+// that isn't known locally fails with ErrRefNotLocal instead of being
+// fetched.
+func Checkout(ctx context.Context, dir, ref string, offline bool) error {
+	if err := checkRef(ctx, ref); err != nil {
+		return err
+	}
+
+	// git checkout only learned --end-of-options in 2.43, so a trailing --
+	// keeps the ref from being read as a path instead
+	output, err := runGit(ctx, dir, "checkout", "--quiet", ref, "--")
+	if err == nil {
+		return nil
+	}
+	if offline {
+		if _, verr := runGit(ctx, dir, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}"); verr != nil {
+			return fmt.Errorf("%w: %s", ErrRefNotLocal, ref)
+		}
+		return commandError(ErrCheckoutFailed, output, err)
+	}
+
+	if output, err := runGit(ctx, dir, "fetch", "--quiet", "--end-of-options", "origin", ref); err != nil {
+		return commandError(ErrCheckoutFailed, output, err)
+	}
+
+	// Fetching a branch updates its remote-tracking branch so checkout can
+	// create it; tags and commits are only available as FETCH_HEAD
+	if _, err := runGit(ctx, dir, "checkout", "--quiet", ref, "--"); err == nil {
+		return nil
+	}
+	if output, err := runGit(ctx, dir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return commandError(ErrCheckoutFailed, output, err)
+	}
+	return nil
+}
+
+// checkRef rejects refs that git would take as an option or that aren't
+// valid branch or tag names, since they come from URLs
+func checkRef(ctx context.Context, ref string) error {
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("%w: %q", ErrInvalidRef, ref)
+	}
+	if _, err := runGit(ctx, "", "check-ref-format", "--allow-onelevel", ref); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidRef, ref)
+	}
+	return nil
+}
+
+// SparseCheckout adds paths to the sparse-checkout of the repository in dir.
+// Repositories without a sparse-checkout already have every path checked out
+// and are left alone.
+func SparseCheckout(ctx context.Context, dir string, paths []string) error {
+	if output, err := runGit(ctx, dir, "config", "--bool", "core.sparseCheckout"); err != nil || strings.TrimSpace(string(output)) != "true" {
+		return nil
+	}
+
+	args := append([]string{"sparse-checkout", "add", "--"}, paths...)
+	if output, err := runGit(ctx, dir, args...); err != nil {
+		return commandError(fmt.Errorf("%w: sparse-checkout", ErrCheckoutFailed), output, err)
+	}
+	return nil
+}
+
+// UpdateSubmodules initializes and checks out the submodules of the
+// repository in dir, recursively. Shallow fetches only the recorded commit of
+// each submodule, like git clone --shallow-submodules. Every submodule is
+// attempted, and the error names each one that failed.
+func UpdateSubmodules(ctx context.Context, dir string, shallow bool) error {
+	paths, err := submodulePaths(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSubmoduleFailed, err)
+	}
+
+	var errs []error
+	for _, path := range paths {
+		args := []string{"submodule", "--quiet", "update", "--init", "--recursive"}
+		if shallow {
+			args = append(args, "--depth", "1")
+		}
+		args = append(args, "--", path)
+		if output, err := runGit(ctx, dir, args...); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: %w", ErrSubmoduleFailed, err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %s", path, strings.TrimSpace(string(output))))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrSubmoduleFailed, errors.Join(errs...))
+	}
+	return nil
+}
+
+// submodulePaths returns the paths of the submodules declared in the
+// .gitmodules file of the repository in dir
+func submodulePaths(ctx context.Context, dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	output, err := runGit(ctx, dir, "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`)
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrCloneFailed, string(output))
+		// Exit status 1 means no submodule has a path
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid .gitmodules: %s", strings.TrimSpace(string(output)))
+	}
+
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if _, path, ok := strings.Cut(line, " "); ok {
+			paths = append(paths, path)
+		}
 	}
+	return paths, nil
+}
 
+// Init creates a repository in dir and commits every file in it
+func Init(ctx context.Context, dir string) error {
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"add", "--all"},
+		{"commit", "--quiet", "--allow-empty", "-m", "Initial commit"},
+	}
+	for _, args := range steps {
+		if output, err := runGit(ctx, dir, args...); err != nil {
+			return commandError(ErrInitFailed, output, err)
+		}
+	}
 	return nil
 }
 
-// IsValidURL checks if the given string is a valid git URL or local repo path
+// OnBranch reports whether the repository in dir has a branch checked out,
+// as opposed to a detached HEAD
+func OnBranch(ctx context.Context, dir string) bool {
+	_, err := runGit(ctx, dir, "symbolic-ref", "--quiet", "HEAD")
+	return err == nil
+}
+
+// runGit runs git with args, in dir when it is not empty, and returns its
+// combined output. When ctx is done, git is interrupted so it can clean up
+// after itself, then killed if it doesn't exit, and the context's error is
+// returned.
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Cancel = func() error {
+		// Interrupts are not supported on Windows
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cancelWaitDelay
+
+	output, err := cmd.CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	return output, err
+}
+
+// commandError reports a failed git command as sentinel with git's output,
+// or with the context's error when the command was canceled
+func commandError(sentinel error, output []byte, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+	return fmt.Errorf("%w: %s", sentinel, strings.TrimSpace(string(output)))
+}
+
+// runGitRetry runs git like runGit, trying again under policy when it fails
+// because of network trouble. Git cleans up after a failed clone, so clones
+// can be retried as they are.
+func runGitRetry(ctx context.Context, policy retry.Policy, dir string, args ...string) ([]byte, error) {
+	var output []byte
+	err := policy.Do(ctx, func() error {
+		var err error
+		output, err = runGit(ctx, dir, args...)
+		if err != nil && isTransient(string(output), policy) {
+			return retry.Retryable(err, 0)
+		}
+		return err
+	})
+	return output, err
+}
+
+// transientMessages are parts of git error output caused by the network
+// rather than by the repository or the request
+var transientMessages = []string{
+	"connection reset",
+	"connection timed out",
+	"operation timed out",
+	"the remote end hung up unexpectedly",
+	"unexpected disconnect",
+	"early eof",
+}
+
+// httpErrorPattern matches git's report of an HTTP error status
+var httpErrorPattern = regexp.MustCompile(`returned error: (\d{3})|HTTP (\d{3})`)
+
+// isTransient reports whether git failed with output showing a failure
+// worth retrying. HTTP errors are retried when policy retries their status.
+func isTransient(output string, policy retry.Policy) bool {
+	if m := httpErrorPattern.FindStringSubmatch(output); m != nil {
+		code, _ := strconv.Atoi(m[1] + m[2])
+		return policy.RetryStatus(code)
+	}
+
+	output = strings.ToLower(output)
+	for _, message := range transientMessages {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidURL checks if the given string is a valid git URL or local repo path.
+// Remote URLs need a .git suffix unless they point at a repository on one of
+// the DefaultForges.
 func IsValidURL(url string) bool {
 	// Check if it's a local path that exists and is a git repo
 	if IsGitRepo(url) {
@@ -75,17 +400,174 @@ func IsValidURL(url string) bool {
 
 	// Check for SSH format (git@host:user/repo.git)
 	if strings.HasPrefix(url, "git@") && strings.Contains(url, ":") {
-		return strings.HasSuffix(url, ".git")
+		return strings.HasSuffix(url, ".git") || isForgeRepo(url)
 	}
 
 	// Check for HTTPS/Git protocol
 	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "git://") {
-		return strings.HasSuffix(url, ".git")
+		return strings.HasSuffix(url, ".git") || isForgeRepo(url)
 	}
 
 	return false
 }
 
+// isForgeRepo reports whether url is the plain repository URL of one of the
+// DefaultForges, such as https://github.com/user/repo
+func isForgeRepo(url string) bool {
+	repoPath, ok := RepoPath(url)
+	if !ok {
+		return false
+	}
+	parts := strings.Split(repoPath, "/")
+	for _, forge := range DefaultForges {
+		if strings.EqualFold(parts[0], forge.Host) {
+			return forge.Type == ForgeGitLab || len(parts) == 3
+		}
+	}
+	return false
+}
+
+// IsRemoteRepo probes url with git ls-remote to find out whether it serves a
+// git repository. Credential prompts are disabled so the probe never blocks
+// on input. URLs with a query string are never probed: git appends its own
+// paths to the URL, which would land in the query and could be answered by
+// whatever resource the URL serves.
+func IsRemoteRepo(ctx context.Context, url string) bool {
+	if strings.Contains(url, "://") && strings.Contains(url, "?") {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", url)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd.Run() == nil
+}
+
+// listRefs returns the branch and tag names of the remote repository
+func listRefs(ctx context.Context, url string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", "--tags", url)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(fields[1], "^{}")
+		name = strings.TrimPrefix(name, "refs/heads/")
+		name = strings.TrimPrefix(name, "refs/tags/")
+		refs[name] = true
+	}
+	return refs, nil
+}
+
+// NormalizeURL reduces a git URL to a comparable host/path form, so the SSH
+// and HTTPS URLs of a repository are equal: git@github.com:user/repo.git and
+// https://github.com/user/repo both become github.com/user/repo. Local
+// repository paths are made absolute.
+func NormalizeURL(rawURL string) string {
+	u := strings.TrimSpace(rawURL)
+	u = strings.TrimSuffix(u, "/")
+	u = strings.TrimSuffix(u, ".git")
+
+	// URL syntax: scheme://[user@]host[:port]/path
+	if strings.Contains(u, "://") {
+		parsed, err := url.Parse(u)
+		if err == nil {
+			if parsed.Scheme == "file" {
+				return filepath.Clean(parsed.Path)
+			}
+			return strings.ToLower(parsed.Hostname()) + "/" + strings.TrimPrefix(parsed.Path, "/")
+		}
+	}
+
+	// scp-like syntax: [user@]host:path. A single letter before the colon
+	// is a Windows drive, not a host.
+	if i := strings.Index(u, ":"); i > 1 && !strings.ContainsAny(u[:i], `/\`) {
+		host := u[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		return strings.ToLower(host) + "/" + strings.TrimPrefix(u[i+1:], "/")
+	}
+
+	// Local repository path
+	if abs, err := filepath.Abs(u); err == nil {
+		return abs
+	}
+	return u
+}
+
+// RepoPath returns the host/owner/repo path of a remote git URL, used to
+// organize clones under a common root. It returns false for local
+// repositories and URLs without a repository path.
+func RepoPath(url string) (string, bool) {
+	normalized := NormalizeURL(url)
+	if filepath.IsAbs(normalized) {
+		return "", false
+	}
+
+	parts := strings.Split(normalized, "/")
+	if len(parts) < 2 {
+		return "", false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	return normalized, true
+}
+
+// SameRepo reports whether two git URLs refer to the same repository
+func SameRepo(a, b string) bool {
+	return strings.EqualFold(NormalizeURL(a), NormalizeURL(b))
+}
+
+// RemoteURL returns the URL of the named remote of the repository in dir
+func RemoteURL(dir, remote string) (string, error) {
+	cmd := exec.Command("git", "-C", dir, "config", "--get", "remote."+remote+".url")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("remote %s not found in %s", remote, dir)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Pull fetches the current branch of the repository in dir and
+// fast-forwards it, refusing to create merge commits
+func Pull(ctx context.Context, dir string) error {
+	if output, err := runGit(ctx, dir, "pull", "--ff-only"); err != nil {
+		return commandError(ErrPullFailed, output, err)
+	}
+	return nil
+}
+
+// SplitRef splits a url#ref spec into the URL and the ref. Only remote URLs
+// and shorthands are split, so local paths containing "#" are left alone.
+func SplitRef(spec string) (string, string) {
+	i := strings.LastIndex(spec, "#")
+	if i <= 0 || i == len(spec)-1 {
+		return spec, ""
+	}
+
+	url := spec[:i]
+	if strings.Contains(url, "://") || strings.Contains(url, "@") || strings.Index(url, ":") > 1 {
+		return url, spec[i+1:]
+	}
+	return spec, ""
+}
+
 // GetRepoName extracts the repository name from a git URL
 func GetRepoName(url string) string {
 	// Remove .git suffix if present
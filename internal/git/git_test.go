@@ -1,10 +1,15 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/deblasis/take/internal/retry"
 )
 
 func TestIsValidURL(t *testing.T) {
@@ -40,8 +45,18 @@ func TestIsValidURL(t *testing.T) {
 			want: true,
 		},
 		{
-			name: "invalid URL - no .git suffix",
+			name: "valid forge URL without .git suffix",
 			url:  "https://github.com/user/repo",
+			want: true,
+		},
+		{
+			name: "invalid URL - no .git suffix on unknown host",
+			url:  "https://example.com/user/repo",
+			want: false,
+		},
+		{
+			name: "invalid URL - forge page that is not a repository",
+			url:  "https://github.com/user/repo/issues",
 			want: false,
 		},
 		{
@@ -107,6 +122,198 @@ func TestGetRepoName(t *testing.T) {
 	}
 }
 
+func TestSameRepo(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "SSH and HTTPS",
+			a:    "git@github.com:user/repo.git",
+			b:    "https://github.com/user/repo",
+			want: true,
+		},
+		{
+			name: "SSH URL syntax with port",
+			a:    "ssh://git@github.com:22/user/repo.git",
+			b:    "https://github.com/user/repo.git",
+			want: true,
+		},
+		{
+			name: "trailing slash and case",
+			a:    "https://GitHub.com/User/Repo/",
+			b:    "git@github.com:user/repo.git",
+			want: true,
+		},
+		{
+			name: "different repositories",
+			a:    "git@github.com:user/repo.git",
+			b:    "git@github.com:user/other.git",
+			want: false,
+		},
+		{
+			name: "different hosts",
+			a:    "https://github.com/user/repo.git",
+			b:    "https://gitlab.com/user/repo.git",
+			want: false,
+		},
+		{
+			name: "local paths",
+			a:    "/tmp/repo",
+			b:    "file:///tmp/repo/",
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameRepo(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameRepo(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "HTTPS URL",
+			url:    "https://github.com/user/repo.git",
+			want:   "github.com/user/repo",
+			wantOK: true,
+		},
+		{
+			name:   "SSH URL",
+			url:    "git@gitlab.com:group/subgroup/repo.git",
+			want:   "gitlab.com/group/subgroup/repo",
+			wantOK: true,
+		},
+		{
+			name: "local path",
+			url:  "/tmp/repo",
+		},
+		{
+			name: "path traversal",
+			url:  "https://github.com/user/../../repo.git",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RepoPath(tt.url)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("RepoPath() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRemoteURL(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := exec.Command("git", "init", tmpDir).Run(); err != nil {
+		t.Fatalf("Failed to init test repo: %v", err)
+	}
+
+	if _, err := RemoteURL(tmpDir, "origin"); err == nil {
+		t.Error("RemoteURL() expected error for missing remote")
+	}
+
+	cmd := exec.Command("git", "remote", "add", "origin", "git@github.com:user/repo.git")
+	cmd.Dir = tmpDir
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to add remote: %v", err)
+	}
+
+	got, err := RemoteURL(tmpDir, "origin")
+	if err != nil {
+		t.Fatalf("RemoteURL() error = %v", err)
+	}
+	if got != "git@github.com:user/repo.git" {
+		t.Errorf("RemoteURL() = %v, want %v", got, "git@github.com:user/repo.git")
+	}
+}
+
+func TestSplitRef(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantURL string
+		wantRef string
+	}{
+		{"https://github.com/user/repo.git#v1.2.0", "https://github.com/user/repo.git", "v1.2.0"},
+		{"git@github.com:user/repo.git#main", "git@github.com:user/repo.git", "main"},
+		{"gh:user/repo#feature/x", "gh:user/repo", "feature/x"},
+		{"https://github.com/user/repo.git", "https://github.com/user/repo.git", ""},
+		{"https://github.com/user/repo.git#", "https://github.com/user/repo.git#", ""},
+		{"notes#1", "notes#1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			url, ref := SplitRef(tt.spec)
+			if url != tt.wantURL || ref != tt.wantRef {
+				t.Errorf("SplitRef() = %q, %q, want %q, %q", url, ref, tt.wantURL, tt.wantRef)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		policy retry.Policy
+		want   bool
+	}{
+		{
+			name:   "connection reset",
+			output: "error: RPC failed; curl 56 Recv failure: Connection reset by peer\nfatal: early EOF",
+			want:   true,
+		},
+		{
+			name:   "hung up",
+			output: "fatal: the remote end hung up unexpectedly",
+			want:   true,
+		},
+		{
+			name:   "server error",
+			output: "fatal: unable to access 'https://example.com/repo.git/': The requested URL returned error: 503",
+			want:   true,
+		},
+		{
+			name:   "status not retried by policy",
+			output: "fatal: unable to access 'https://example.com/repo.git/': The requested URL returned error: 503",
+			policy: retry.Policy{StatusCodes: []int{429}},
+		},
+		{
+			name:   "request too large",
+			output: "error: RPC failed; HTTP 413 curl 22 The requested URL returned error: 413\nfatal: the remote end hung up unexpectedly",
+		},
+		{
+			name:   "repository not found",
+			output: "remote: Repository not found.\nfatal: repository 'https://github.com/user/missing.git/' not found",
+		},
+		{
+			name:   "unknown branch",
+			output: "fatal: Remote branch nope not found in upstream origin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.output, tt.policy); got != tt.want {
+				t.Errorf("isTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsGitRepo(t *testing.T) {
 	// Create a temporary directory
 	tmpDir, err := os.MkdirTemp("", "git-test-*")
@@ -206,10 +413,27 @@ func TestClone(t *testing.T) {
 		t.Fatalf("Failed to commit: %v", err)
 	}
 
+	// Tag the first commit and move the default branch past it, leaving a
+	// branch behind
+	runGit := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	firstCommit := runGit(testRepoDir, "rev-parse", "HEAD")
+	runGit(testRepoDir, "tag", "v1.0")
+	runGit(testRepoDir, "branch", "feature")
+	runGit(testRepoDir, "commit", "--allow-empty", "-m", "Second commit")
+
 	tests := []struct {
-		name    string
-		opts    CloneOptions
-		wantErr bool
+		name     string
+		opts     CloneOptions
+		wantErr  bool
+		wantHead string
 	}{
 		{
 			name: "invalid URL",
@@ -228,11 +452,66 @@ func TestClone(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "branch",
+			opts: CloneOptions{
+				URL:       testRepoDir,
+				TargetDir: filepath.Join(tmpDir, "branch"),
+				Ref:       "feature",
+			},
+			wantHead: firstCommit,
+		},
+		{
+			name: "tag",
+			opts: CloneOptions{
+				URL:       testRepoDir,
+				TargetDir: filepath.Join(tmpDir, "tag"),
+				Ref:       "v1.0",
+			},
+			wantHead: firstCommit,
+		},
+		{
+			name: "commit with shallow depth",
+			opts: CloneOptions{
+				URL:       "file://" + filepath.ToSlash(testRepoDir),
+				TargetDir: filepath.Join(tmpDir, "commit"),
+				Depth:     1,
+				Ref:       firstCommit,
+			},
+			wantHead: firstCommit,
+		},
+		{
+			name: "abbreviated commit",
+			opts: CloneOptions{
+				URL:       testRepoDir,
+				TargetDir: filepath.Join(tmpDir, "short-commit"),
+				Ref:       firstCommit[:8],
+			},
+			wantHead: firstCommit,
+		},
+		{
+			name: "option-like ref",
+			opts: CloneOptions{
+				URL:       testRepoDir,
+				TargetDir: filepath.Join(tmpDir, "option-ref"),
+				Ref:       "--upload-pack=false",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown ref",
+			opts: CloneOptions{
+				URL:       testRepoDir,
+				TargetDir: filepath.Join(tmpDir, "unknown-ref"),
+				Ref:       "does-not-exist",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := Clone(tt.opts)
+			err := Clone(context.Background(), tt.opts)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Clone() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -243,6 +522,267 @@ func TestClone(t *testing.T) {
 					t.Error("Clone() did not create a valid git repository")
 				}
 			}
+
+			if tt.wantHead != "" {
+				if head := runGit(tt.opts.TargetDir, "rev-parse", "HEAD"); head != tt.wantHead {
+					t.Errorf("Clone() HEAD = %v, want %v", head, tt.wantHead)
+				}
+			}
+		})
+	}
+
+	t.Run("checkout option-like ref", func(t *testing.T) {
+		marker := filepath.Join(tmpDir, "pwned")
+		err := Checkout(context.Background(), filepath.Join(tmpDir, "valid"), "--upload-pack=touch "+marker+";false", false)
+		if !errors.Is(err, ErrInvalidRef) {
+			t.Errorf("Checkout() error = %v, want %v", err, ErrInvalidRef)
+		}
+		if _, err := os.Stat(marker); !os.IsNotExist(err) {
+			t.Error("Checkout() ran the ref as a git option")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		targetDir := filepath.Join(tmpDir, "canceled")
+		err := Clone(ctx, CloneOptions{URL: testRepoDir, TargetDir: targetDir})
+		if !errors.Is(err, ErrCloneFailed) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Clone() error = %v, want %v wrapping %v", err, ErrCloneFailed, context.Canceled)
+		}
+		if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
+			t.Errorf("Clone() left %s behind", targetDir)
+		}
+	})
+}
+
+func TestCloneSubmodules(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("Git is not installed, skipping clone tests")
+	}
+
+	// Allow file:// submodules, which git blocks by default
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+	t.Setenv("GIT_AUTHOR_NAME", "Test User")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test User")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	tmpDir := t.TempDir()
+	runGit := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	newRepo := func(name string) string {
+		dir := filepath.Join(tmpDir, name)
+		runGit(tmpDir, "init", "--quiet", name)
+		if err := os.WriteFile(filepath.Join(dir, name+".txt"), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		runGit(dir, "add", ".")
+		runGit(dir, "commit", "--quiet", "-m", "Initial commit")
+		return dir
+	}
+
+	// parent -> lib -> inner
+	inner := newRepo("inner")
+	lib := newRepo("lib")
+	runGit(lib, "submodule", "--quiet", "add", "file://"+filepath.ToSlash(inner), "inner")
+	runGit(lib, "commit", "--quiet", "-m", "Add inner")
+	parent := newRepo("parent")
+	runGit(parent, "submodule", "--quiet", "add", "file://"+filepath.ToSlash(lib), "lib")
+	runGit(parent, "commit", "--quiet", "-m", "Add lib")
+
+	// broken -> gone, whose repository no longer exists
+	gone := newRepo("gone")
+	broken := newRepo("broken")
+	runGit(broken, "submodule", "--quiet", "add", "file://"+filepath.ToSlash(lib), "lib")
+	runGit(broken, "submodule", "--quiet", "add", "file://"+filepath.ToSlash(gone), "gone")
+	runGit(broken, "commit", "--quiet", "-m", "Add submodules")
+	if err := os.RemoveAll(gone); err != nil {
+		t.Fatalf("Failed to remove submodule repo: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      CloneOptions
+		wantErr   string
+		wantFiles []string
+		wantEmpty []string
+	}{
+		{
+			name: "submodules not requested",
+			opts: CloneOptions{
+				URL:       parent,
+				TargetDir: filepath.Join(tmpDir, "clones", "plain"),
+			},
+			wantEmpty: []string{"lib"},
+		},
+		{
+			name: "recursive",
+			opts: CloneOptions{
+				URL:        parent,
+				TargetDir:  filepath.Join(tmpDir, "clones", "recursive"),
+				Submodules: true,
+			},
+			wantFiles: []string{"lib/lib.txt", "lib/inner/inner.txt"},
+		},
+		{
+			name: "recursive shallow",
+			opts: CloneOptions{
+				URL:        "file://" + filepath.ToSlash(parent),
+				TargetDir:  filepath.Join(tmpDir, "clones", "shallow"),
+				Depth:      1,
+				Submodules: true,
+			},
+			wantFiles: []string{"lib/lib.txt", "lib/inner/inner.txt"},
+		},
+		{
+			name: "failed submodule",
+			opts: CloneOptions{
+				URL:        broken,
+				TargetDir:  filepath.Join(tmpDir, "clones", "broken"),
+				Submodules: true,
+			},
+			wantErr:   "gone:",
+			wantFiles: []string{"lib/lib.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Clone(context.Background(), tt.opts)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Clone() error = %v", err)
+			}
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrSubmoduleFailed) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Clone() error = %v, want %v naming %q", err, ErrSubmoduleFailed, tt.wantErr)
+				}
+			}
+
+			for _, file := range tt.wantFiles {
+				if _, err := os.Stat(filepath.Join(tt.opts.TargetDir, file)); err != nil {
+					t.Errorf("Clone() did not check out %s: %v", file, err)
+				}
+			}
+			for _, dir := range tt.wantEmpty {
+				entries, err := os.ReadDir(filepath.Join(tt.opts.TargetDir, dir))
+				if err != nil || len(entries) != 0 {
+					t.Errorf("Clone() populated %s, want it left uninitialized", dir)
+				}
+			}
+		})
+	}
+}
+
+func TestCloneSparse(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("Git is not installed, skipping clone tests")
+	}
+
+	t.Setenv("GIT_AUTHOR_NAME", "Test User")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test User")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	tmpDir := t.TempDir()
+	runGit := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	repoDir := filepath.Join(tmpDir, "monorepo")
+	for _, file := range []string{"README", "apps/web/main.go", "libs/core/core.go", "tools/build.sh"} {
+		path := filepath.Join(repoDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	runGit(tmpDir, "init", "--quiet", repoDir)
+	runGit(repoDir, "config", "uploadpack.allowFilter", "true")
+	runGit(repoDir, "add", ".")
+	runGit(repoDir, "commit", "--quiet", "-m", "Initial commit")
+	commit := runGit(repoDir, "rev-parse", "HEAD")
+	runGit(repoDir, "commit", "--quiet", "--allow-empty", "-m", "Second commit")
+	repoURL := "file://" + filepath.ToSlash(repoDir)
+
+	tests := []struct {
+		name        string
+		opts        CloneOptions
+		wantFiles   []string
+		wantMissing []string
+		wantFilter  string
+	}{
+		{
+			name: "partial clone",
+			opts: CloneOptions{
+				URL:       repoURL,
+				TargetDir: filepath.Join(tmpDir, "partial"),
+				Filter:    "blob:none",
+			},
+			wantFiles:  []string{"README", "apps/web/main.go", "libs/core/core.go", "tools/build.sh"},
+			wantFilter: "blob:none",
+		},
+		{
+			name: "sparse paths",
+			opts: CloneOptions{
+				URL:       repoURL,
+				TargetDir: filepath.Join(tmpDir, "sparse"),
+				Filter:    "tree:0",
+				Sparse:    []string{"apps/web", "libs"},
+			},
+			wantFiles:   []string{"README", "apps/web/main.go", "libs/core/core.go"},
+			wantMissing: []string{"tools"},
+			wantFilter:  "tree:0",
+		},
+		{
+			name: "sparse commit",
+			opts: CloneOptions{
+				URL:       repoURL,
+				TargetDir: filepath.Join(tmpDir, "sparse-commit"),
+				Filter:    "blob:none",
+				Sparse:    []string{"libs/core"},
+				Ref:       commit,
+			},
+			wantFiles:   []string{"README", "libs/core/core.go"},
+			wantMissing: []string{"apps", "tools"},
+			wantFilter:  "blob:none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Clone(context.Background(), tt.opts); err != nil {
+				t.Fatalf("Clone() error = %v", err)
+			}
+
+			for _, file := range tt.wantFiles {
+				if _, err := os.Stat(filepath.Join(tt.opts.TargetDir, filepath.FromSlash(file))); err != nil {
+					t.Errorf("Clone() did not check out %s: %v", file, err)
+				}
+			}
+			for _, file := range tt.wantMissing {
+				if _, err := os.Stat(filepath.Join(tt.opts.TargetDir, filepath.FromSlash(file))); !os.IsNotExist(err) {
+					t.Errorf("Clone() checked out %s outside the sparse paths", file)
+				}
+			}
+			if filter := runGit(tt.opts.TargetDir, "config", "remote.origin.partialclonefilter"); filter != tt.wantFilter {
+				t.Errorf("Clone() filter = %v, want %v", filter, tt.wantFilter)
+			}
 		})
 	}
 }
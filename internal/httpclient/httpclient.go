@@ -0,0 +1,226 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidCAFile = errors.New("invalid CA file")
+	ErrInvalidProxy  = errors.New("invalid proxy URL")
+	ErrInvalidHost   = errors.New("invalid host pattern")
+)
+
+// Config describes the HTTP client used for downloads
+type Config struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in
+	// addition to the system ones, e.g. a corporate root
+	CAFile string `json:"ca_file,omitempty"`
+	// InsecureSkipVerify disables TLS certificate verification
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// Proxy is the URL of the proxy requests go through, http, https or
+	// socks5. Empty uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	Proxy string `json:"proxy,omitempty"`
+	// Headers are added to every request that doesn't set them already,
+	// but not to the other hosts a request is redirected to
+	Headers map[string]string `json:"headers,omitempty"`
+	// UserAgent is the User-Agent of requests, unless Headers sets one
+	UserAgent string `json:"user_agent,omitempty"`
+	// Hosts are headers, such as credentials, sent only to matching hosts
+	// over https
+	Hosts []HostHeaders `json:"hosts,omitempty"`
+	// Netrc is the netrc file logins are read from. Empty uses $NETRC, else
+	// ~/.netrc if it exists.
+	Netrc string `json:"netrc,omitempty"`
+}
+
+// HostHeaders are headers sent to the hosts matching a pattern
+type HostHeaders struct {
+	// Host is a host name, "*.example.com" for its subdomains, with an
+	// optional port to match only that port
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// TokenEnvPrefix starts the environment variables holding bearer tokens:
+// TAKE_TOKEN_ARTIFACTS_EXAMPLE_COM for artifacts.example.com
+const TokenEnvPrefix = "TAKE_TOKEN_"
+
+// New builds a client from the config, based on the default transport
+func New(cfg Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidProxy, cfg.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("%w: %s: use an http, https or socks5 URL", ErrInvalidProxy, cfg.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	header := make(http.Header)
+	for name, value := range cfg.Headers {
+		header.Set(name, value)
+	}
+
+	logins, err := loadNetrc(cfg.Netrc)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range cfg.Hosts {
+		if host.Host == "" {
+			return nil, fmt.Errorf("%w: host headers need a host pattern", ErrInvalidHost)
+		}
+	}
+
+	return &http.Client{Transport: &headerTransport{
+		base:      transport,
+		header:    header,
+		userAgent: cfg.UserAgent,
+		hosts:     cfg.Hosts,
+		netrc:     logins,
+	}}, nil
+}
+
+// TokenEnv returns the name of the environment variable holding the bearer
+// token for host
+func TokenEnv(host string) string {
+	name := []byte(strings.ToUpper(host))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	return TokenEnvPrefix + string(name)
+}
+
+// matchHost reports whether the host of u matches pattern
+func matchHost(pattern string, u *url.URL) bool {
+	host := u.Hostname()
+	if _, _, err := net.SplitHostPort(pattern); err == nil {
+		// Compare ports too, giving the default port of the scheme
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		host = net.JoinHostPort(host, port)
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+		return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(host, pattern)
+}
+
+// loadCAFile returns the system certificate pool with the certificates of
+// a PEM file added
+func loadCAFile(path string) (*x509.CertPool, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCAFile, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// Minimal systems may have no certificates of their own
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: no certificates found in %s", ErrInvalidCAFile, path)
+	}
+	return pool, nil
+}
+
+// headerTransport adds headers to the requests that don't set them.
+// Credentials are chosen by the host of each request, so the client following
+// a redirect to another host never sends them there, and are only sent over
+// https. The headers for all hosts stay with the host first requested.
+type headerTransport struct {
+	base      http.RoundTripper
+	header    http.Header
+	userAgent string
+	hosts     []HostHeaders
+	netrc     netrc
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(req.Context())
+	setMissing := func(name, value string) {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	// The first to set a header wins: the request, the token from the
+	// environment, the host headers, netrc, then the headers for all hosts.
+	// Anyone on the way could read credentials sent over plain http.
+	if req.URL.Scheme == "https" {
+		if token := os.Getenv(TokenEnv(req.URL.Hostname())); token != "" {
+			setMissing("Authorization", "Bearer "+token)
+		}
+		for _, host := range t.hosts {
+			if matchHost(host.Host, req.URL) {
+				for name, value := range host.Headers {
+					setMissing(name, value)
+				}
+			}
+		}
+		if login, ok := t.netrc[strings.ToLower(req.URL.Hostname())]; ok && req.Header.Get("Authorization") == "" {
+			req.SetBasicAuth(login.login, login.password)
+		}
+	}
+	if strings.EqualFold(req.URL.Host, originalURL(req).Host) {
+		for name, values := range t.header {
+			if req.Header.Get(name) == "" {
+				req.Header[name] = values
+			}
+		}
+	}
+	if t.userAgent != "" {
+		setMissing("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// originalURL returns the URL of the request the client was asked for,
+// before the redirects that led to req
+func originalURL(req *http.Request) *url.URL {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL
+}
@@ -0,0 +1,329 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	caFile := filepath.Join(tmpDir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(tmpDir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantErr    error
+		wantGetErr bool
+	}{
+		{name: "untrusted certificate", wantGetErr: true},
+		{name: "CA file", cfg: Config{CAFile: caFile}},
+		{name: "insecure", cfg: Config{InsecureSkipVerify: true}},
+		{name: "missing CA file", cfg: Config{CAFile: filepath.Join(tmpDir, "missing.pem")}, wantErr: ErrInvalidCAFile},
+		{name: "CA file without certificates", cfg: Config{CAFile: notPEM}, wantErr: ErrInvalidCAFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("New() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			resp, err := client.Get(ts.URL)
+			if (err != nil) != tt.wantGetErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantGetErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestNewProxy(t *testing.T) {
+	// A plain HTTP proxy receives the absolute URL of the request
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("from proxy"))
+	}))
+	defer proxy.Close()
+
+	client, err := New(Config{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resp, err := client.Get("http://archive.example.com/file.tar.gz")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "from proxy" || proxied != "http://archive.example.com/file.tar.gz" {
+		t.Errorf("Get() = %q through proxy for %q, want the proxy to fetch the URL", body, proxied)
+	}
+
+	for _, invalid := range []string{"ftp://proxy:21", "proxy:3128", "://"} {
+		if _, err := New(Config{Proxy: invalid}); !errors.Is(err, ErrInvalidProxy) {
+			t.Errorf("New() with proxy %q error = %v, want %v", invalid, err, ErrInvalidProxy)
+		}
+	}
+}
+
+func TestNewHeaders(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	client, err := New(Config{
+		UserAgent: "take/1.0",
+		Headers:   map[string]string{"X-Team": "platform", "Accept": "application/octet-stream"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	want := map[string]string{
+		"User-Agent": "take/1.0",
+		"X-Team":     "platform",
+		// Headers set by the request win
+		"Accept": "text/plain",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+	if req.Header.Get("X-Team") != "" {
+		t.Error("Do() modified the caller's request")
+	}
+
+	// Headers stay with the host first requested, and the user agent goes
+	// to every host
+	var gotOther http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotOther = r.Header.Clone()
+	}))
+	defer other.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(strings.Replace(other.URL, "127.0.0.1", "localhost", 1), http.StatusFound))
+	defer redirect.Close()
+	resp, err = client.Get(redirect.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if gotOther.Get("X-Team") != "" {
+		t.Errorf("redirected host received X-Team = %q", gotOther.Get("X-Team"))
+	}
+	if ua := gotOther.Get("User-Agent"); ua != "take/1.0" {
+		t.Errorf("redirected host received User-Agent = %q, want %q", ua, "take/1.0")
+	}
+
+	// Headers override the user agent
+	client, _ = New(Config{UserAgent: "take/1.0", Headers: map[string]string{"user-agent": "custom"}})
+	if resp, err := client.Get(ts.URL); err == nil {
+		resp.Body.Close()
+	}
+	if ua := got.Get("User-Agent"); !strings.EqualFold(ua, "custom") {
+		t.Errorf("User-Agent = %q, want %q", ua, "custom")
+	}
+}
+
+func TestNewCredentials(t *testing.T) {
+	// Both servers listen on 127.0.0.1; the other is reached as localhost
+	// so that it is another host
+	var gotOther http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotOther = r.Header.Clone()
+	}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	// Credentials are only sent over https
+	var gotPlain http.Header
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPlain = r.Header.Clone()
+	}))
+	defer plain.Close()
+
+	var got http.Header
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, otherURL+"/file", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "https://")
+
+	tmpDir := t.TempDir()
+	caFile := filepath.Join(tmpDir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	netrcFile := filepath.Join(tmpDir, "netrc")
+	if err := os.WriteFile(netrcFile, []byte("machine 127.0.0.1 login ci password s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		token string
+		want  map[string]string
+	}{
+		{
+			name: "netrc",
+			cfg:  Config{Netrc: netrcFile},
+			want: map[string]string{"Authorization": "Basic Y2k6czNjcmV0"},
+		},
+		{
+			name:  "token wins over netrc",
+			cfg:   Config{Netrc: netrcFile},
+			token: "t0ken",
+			want:  map[string]string{"Authorization": "Bearer t0ken"},
+		},
+		{
+			name: "host headers",
+			cfg: Config{Hosts: []HostHeaders{
+				{Host: "127.0.0.1:1", Headers: map[string]string{"X-Api-Key": "other port"}},
+				{Host: host, Headers: map[string]string{"X-Api-Key": "k3y"}},
+				{Host: "*.example.com", Headers: map[string]string{"Authorization": "Bearer elsewhere"}},
+				{Host: "127.0.0.1", Headers: map[string]string{"X-Api-Key": "any port"}},
+			}},
+			want: map[string]string{"X-Api-Key": "k3y", "Authorization": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("NETRC", "")
+			t.Setenv(TokenEnv("127.0.0.1"), tt.token)
+			tt.cfg.CAFile = caFile
+			client, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			resp, err := client.Get(ts.URL + "/file")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			resp.Body.Close()
+			for name, value := range tt.want {
+				if got.Get(name) != value {
+					t.Errorf("%s = %q, want %q", name, got.Get(name), value)
+				}
+			}
+
+			// Credentials stay with their host when redirected
+			resp, err = client.Get(ts.URL + "/redirect")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			resp.Body.Close()
+			if gotOther == nil {
+				t.Fatal("redirect was not followed")
+			}
+			for name := range tt.want {
+				if gotOther.Get(name) != "" {
+					t.Errorf("redirected host received %s = %q", name, gotOther.Get(name))
+				}
+			}
+
+			resp, err = client.Get(plain.URL + "/file")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			resp.Body.Close()
+			for name := range tt.want {
+				if gotPlain.Get(name) != "" {
+					t.Errorf("plain http request sent %s = %q", name, gotPlain.Get(name))
+				}
+			}
+		})
+	}
+
+	if _, err := New(Config{Hosts: []HostHeaders{{Headers: map[string]string{"X-Api-Key": "k3y"}}}}); !errors.Is(err, ErrInvalidHost) {
+		t.Errorf("New() with an empty host pattern error = %v, want %v", err, ErrInvalidHost)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"artifacts.example.com", "https://artifacts.example.com/a.tar.gz", true},
+		{"artifacts.example.com", "https://Artifacts.Example.com:8443/a.tar.gz", true},
+		{"artifacts.example.com", "https://example.com/a.tar.gz", false},
+		{"*.example.com", "https://artifacts.example.com/a.tar.gz", true},
+		{"*.example.com", "https://example.com/a.tar.gz", false},
+		{"*.example.com", "https://evil-example.com/a.tar.gz", false},
+		{"artifacts.example.com:8443", "https://artifacts.example.com:8443/a.tar.gz", true},
+		{"artifacts.example.com:8443", "https://artifacts.example.com/a.tar.gz", false},
+		{"artifacts.example.com:443", "https://artifacts.example.com/a.tar.gz", true},
+		{"artifacts.example.com:443", "http://artifacts.example.com/a.tar.gz", false},
+		{"[::1]:8080", "http://[::1]:8080/a.tar.gz", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchHost(tt.pattern, u); got != tt.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestTokenEnv(t *testing.T) {
+	for host, want := range map[string]string{
+		"artifacts.example.com": "TAKE_TOKEN_ARTIFACTS_EXAMPLE_COM",
+		"my-host":               "TAKE_TOKEN_MY_HOST",
+		"::1":                   "TAKE_TOKEN___1",
+	} {
+		if got := TokenEnv(host); got != want {
+			t.Errorf("TokenEnv(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
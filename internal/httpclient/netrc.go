@@ -0,0 +1,130 @@
+package httpclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidNetrc = errors.New("invalid netrc file")
+
+// netrcLogin is the login and password of a netrc machine
+type netrcLogin struct {
+	login    string
+	password string
+}
+
+// netrc maps lowercase machine names to their login. The "default" entry
+// is left out: it would send a login to any host, redirects included.
+type netrc map[string]netrcLogin
+
+// loadNetrc reads the netrc file at path. An empty path reads $NETRC, else
+// ~/.netrc, and is no error when that file doesn't exist.
+func loadNetrc(path string) (netrc, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("NETRC")
+		explicit = path != ""
+	}
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	} else if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNetrc, err)
+	}
+	n, err := parseNetrc(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidNetrc, path, err)
+	}
+	return n, nil
+}
+
+// parseNetrc parses the machine, default, login and password tokens of a
+// netrc file, skipping accounts, comments and macro definitions
+func parseNetrc(content string) (netrc, error) {
+	n := make(netrc)
+	var (
+		machine string
+		current *netrcLogin
+		// inMacro skips a macdef body, which ends at an empty line
+		inMacro bool
+	)
+	flush := func() {
+		if current != nil && machine != "" {
+			if _, seen := n[machine]; !seen {
+				// The first entry for a machine wins, as with curl and ftp
+				n[machine] = *current
+			}
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+			switch token {
+			case "default":
+				flush()
+				machine, current = "", &netrcLogin{}
+				continue
+			case "macdef":
+				flush()
+				// The rest of the line names the macro
+				inMacro = true
+				i = len(fields)
+				continue
+			}
+
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("missing value after %q", token)
+			}
+			value := fields[i+1]
+			i++
+			switch token {
+			case "machine":
+				flush()
+				machine, current = strings.ToLower(value), &netrcLogin{}
+			case "login", "password", "account":
+				if current == nil {
+					return nil, fmt.Errorf("%q outside of a machine entry", token)
+				}
+				if token == "login" {
+					current.login = value
+				} else if token == "password" {
+					current.password = value
+				}
+			default:
+				return nil, fmt.Errorf("unknown token %q", token)
+			}
+		}
+	}
+	flush()
+	return n, scanner.Err()
+}
@@ -0,0 +1,100 @@
+package httpclient
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    netrc
+		wantErr bool
+	}{
+		{
+			name: "machines",
+			content: `machine artifacts.example.com login ci password s3cret
+# personal account
+machine Git.Example.com
+	login me
+	account team
+	password hunter2
+`,
+			want: netrc{
+				"artifacts.example.com": {login: "ci", password: "s3cret"},
+				"git.example.com":       {login: "me", password: "hunter2"},
+			},
+		},
+		{
+			name:    "first entry wins",
+			content: "machine a.example.com login first password one\nmachine a.example.com login second password two\n",
+			want:    netrc{"a.example.com": {login: "first", password: "one"}},
+		},
+		{
+			name: "default and macros skipped",
+			content: `machine a.example.com login a password pa
+macdef init
+machine b.example.com login b password pb
+
+default login anonymous password guest
+`,
+			want: netrc{"a.example.com": {login: "a", password: "pa"}},
+		},
+		{
+			name:    "missing value",
+			content: "machine a.example.com login",
+			wantErr: true,
+		},
+		{
+			name:    "login outside of a machine",
+			content: "login me password secret",
+			wantErr: true,
+		},
+		{
+			name:    "unknown token",
+			content: "machine a.example.com user me",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetrc(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNetrc() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNetrc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadNetrc(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "netrc")
+	if err := os.WriteFile(path, []byte("machine a.example.com login a password pa\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", tmpDir)
+
+	// Without a ~/.netrc there are no logins
+	t.Setenv("NETRC", "")
+	if got, err := loadNetrc(""); err != nil || len(got) != 0 {
+		t.Errorf("loadNetrc() without a file = %v, %v, want no logins", got, err)
+	}
+
+	t.Setenv("NETRC", path)
+	if got, err := loadNetrc(""); err != nil || got["a.example.com"].login != "a" {
+		t.Errorf("loadNetrc() from $NETRC = %v, %v, want the login of a.example.com", got, err)
+	}
+
+	// A file asked for must exist
+	if _, err := loadNetrc(filepath.Join(tmpDir, "missing")); !errors.Is(err, ErrInvalidNetrc) {
+		t.Errorf("loadNetrc() of a missing file error = %v, want %v", err, ErrInvalidNetrc)
+	}
+}
@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes how operations that fail for transient reasons, such as
+// a dropped connection or a 503 response, are retried. Zero fields take
+// their value from DefaultPolicy.
+type Policy struct {
+	// Attempts is the total number of tries, 1 disables retries
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes each delay by up to this fraction of it, so that
+	// clients failing together don't retry together. Negative disables it.
+	Jitter float64
+	// StatusCodes are the HTTP response statuses worth retrying
+	StatusCodes []int
+}
+
+// DefaultPolicy tries three times, waiting about 1s and then 2s
+var DefaultPolicy = Policy{
+	Attempts:    3,
+	Backoff:     time.Second,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.2,
+	StatusCodes: []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+}
+
+// sleep waits between attempts, or until ctx is done. Tests replace it.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryableError marks an error as transient
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient, so Do tries again. A positive after is
+// the delay the server asked for, e.g. with a Retry-After header.
+func Retryable(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err, after: after}
+}
+
+// IsRetryable reports whether err was marked with Retryable
+func IsRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable)
+}
+
+// withDefaults fills the zero fields of p from DefaultPolicy
+func (p Policy) withDefaults() Policy {
+	if p.Attempts == 0 {
+		p.Attempts = DefaultPolicy.Attempts
+	}
+	if p.Backoff == 0 {
+		p.Backoff = DefaultPolicy.Backoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = max(DefaultPolicy.MaxBackoff, p.Backoff)
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultPolicy.Jitter
+	}
+	if p.StatusCodes == nil {
+		p.StatusCodes = DefaultPolicy.StatusCodes
+	}
+	return p
+}
+
+// RetryStatus reports whether a response with the HTTP status code is
+// worth retrying
+func (p Policy) RetryStatus(code int) bool {
+	return slices.Contains(p.withDefaults().StatusCodes, code)
+}
+
+// Do calls fn until it succeeds or fails with an error not marked with
+// Retryable, at most Attempts times, and returns its last error. When the
+// server asks to wait longer than MaxBackoff, Do gives up instead. Once ctx
+// is done, Do stops retrying and returns the context's error.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	p = p.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			if errors.Is(err, ctx.Err()) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		var retryable *retryableError
+		if err == nil || attempt >= p.Attempts || !errors.As(err, &retryable) {
+			return err
+		}
+
+		if retryable.after > p.MaxBackoff {
+			return err
+		}
+		if err := sleep(ctx, max(p.delay(attempt), retryable.after)); err != nil {
+			return err
+		}
+	}
+}
+
+// delay returns the wait before retry n, counting from 1
+func (p Policy) delay(n int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < n && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxBackoff)
+
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// RetryAfter parses the value of a Retry-After header, either seconds or an
+// HTTP date, into the delay it asks for. It returns 0 when the value is
+// missing or invalid.
+func RetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// policyJSON is the config file form of a Policy, with durations such as
+// "500ms" or "1m"
+type policyJSON struct {
+	Attempts    int     `json:"attempts,omitempty"`
+	Backoff     string  `json:"backoff,omitempty"`
+	MaxBackoff  string  `json:"max_backoff,omitempty"`
+	Jitter      float64 `json:"jitter,omitempty"`
+	StatusCodes []int   `json:"status_codes,omitempty"`
+}
+
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	var raw policyJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*p = Policy{Attempts: raw.Attempts, Jitter: raw.Jitter, StatusCodes: raw.StatusCodes}
+	var err error
+	if raw.Backoff != "" {
+		if p.Backoff, err = time.ParseDuration(raw.Backoff); err != nil {
+			return fmt.Errorf("invalid retry backoff %q", raw.Backoff)
+		}
+	}
+	if raw.MaxBackoff != "" {
+		if p.MaxBackoff, err = time.ParseDuration(raw.MaxBackoff); err != nil {
+			return fmt.Errorf("invalid retry max_backoff %q", raw.MaxBackoff)
+		}
+	}
+	return nil
+}
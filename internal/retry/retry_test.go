@@ -0,0 +1,200 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	errTransient := errors.New("connection reset")
+	errPermanent := errors.New("not found")
+
+	tests := []struct {
+		name   string
+		policy Policy
+		// errs are returned by the successive calls, nil once exhausted
+		errs       []error
+		wantCalls  int
+		wantErr    error
+		wantSleeps []time.Duration
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:       "transient failures",
+			policy:     Policy{Jitter: -1},
+			errs:       []error{Retryable(errTransient, 0), Retryable(errTransient, 0)},
+			wantCalls:  3,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "permanent failure",
+			errs:      []error{errPermanent},
+			wantCalls: 1,
+			wantErr:   errPermanent,
+		},
+		{
+			name:       "attempts exhausted",
+			policy:     Policy{Attempts: 2, Backoff: time.Millisecond, Jitter: -1},
+			errs:       []error{Retryable(errTransient, 0), Retryable(errTransient, 0), nil},
+			wantCalls:  2,
+			wantErr:    errTransient,
+			wantSleeps: []time.Duration{time.Millisecond},
+		},
+		{
+			name:      "retries disabled",
+			policy:    Policy{Attempts: 1},
+			errs:      []error{Retryable(errTransient, 0)},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+		{
+			name:       "server delay",
+			policy:     Policy{Jitter: -1},
+			errs:       []error{Retryable(errTransient, 5*time.Second)},
+			wantCalls:  2,
+			wantSleeps: []time.Duration{5 * time.Second},
+		},
+		{
+			name:      "server delay beyond max backoff",
+			policy:    Policy{MaxBackoff: time.Minute},
+			errs:      []error{Retryable(errTransient, time.Hour)},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			defer func(s func(context.Context, time.Duration) error) { sleep = s }(sleep)
+			sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			calls := 0
+			err := tt.policy.Do(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Do() made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if len(sleeps) != len(tt.wantSleeps) {
+				t.Fatalf("Do() slept %v, want %v", sleeps, tt.wantSleeps)
+			}
+			for i := range sleeps {
+				if sleeps[i] != tt.wantSleeps[i] {
+					t.Errorf("Do() slept %v, want %v", sleeps, tt.wantSleeps)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	errTransient := errors.New("connection reset")
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		start := time.Now()
+		err := Policy{Backoff: time.Hour}.Do(ctx, func() error {
+			calls++
+			cancel()
+			return Retryable(errTransient, 0)
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+		}
+		if calls != 1 || time.Since(start) > time.Second {
+			t.Errorf("Do() made %d calls in %v, want 1 without waiting", calls, time.Since(start))
+		}
+	})
+
+	t.Run("failure after the deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+		err := Policy{}.Do(ctx, func() error { return Retryable(errTransient, 0) })
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errTransient) {
+			t.Errorf("Do() error = %v, want %v wrapping %v", err, context.DeadlineExceeded, errTransient)
+		}
+	})
+}
+
+func TestDelay(t *testing.T) {
+	policy := Policy{Backoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: -1}.withDefaults()
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := policy.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	// Jitter stays within its fraction of the delay
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.delay(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("delay(1) with jitter = %v, want within 0.5s of 1s", got)
+		}
+	}
+}
+
+func TestRetryStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		code   int
+		want   bool
+	}{
+		{"default 503", Policy{}, 503, true},
+		{"default 429", Policy{}, 429, true},
+		{"default 404", Policy{}, 404, false},
+		{"custom list", Policy{StatusCodes: []int{520}}, 520, true},
+		{"custom list excludes defaults", Policy{StatusCodes: []int{520}}, 503, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.RetryStatus(tt.code); got != tt.want {
+				t.Errorf("RetryStatus(%d) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Tue, 02 Jan 2024 03:04:35 GMT", 30 * time.Second},
+		{"Tue, 02 Jan 2024 03:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("RetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -51,10 +51,10 @@ func (z *Zsh) ChangeDir(path string) string {
 func (z *Zsh) SetupScript() string {
 	return `take() {
 	if [ -z "$1" ]; then
-		echo "Usage: take <directory or git-url>" >&2
+		echo "Usage: take [options] <directory|git-url|archive-url> [dest]" >&2
 		return 1
 	fi
-	take_result=$(take-cli "$1")
+	take_result=$(take-cli "$@")
 	if [ $? -eq 0 ]; then
 		cd "$take_result"
 	else
@@ -74,10 +74,10 @@ func (b *Bash) ChangeDir(path string) string {
 func (b *Bash) SetupScript() string {
 	return `take() {
 	if [ -z "$1" ]; then
-		echo "Usage: take <directory or git-url>" >&2
+		echo "Usage: take [options] <directory|git-url|archive-url> [dest]" >&2
 		return 1
 	fi
-	take_result=$(take-cli "$1")
+	take_result=$(take-cli "$@")
 	if [ $? -eq 0 ]; then
 		cd "$take_result"
 	else
@@ -96,12 +96,11 @@ func (p *PowerShell) ChangeDir(path string) string {
 }
 func (p *PowerShell) SetupScript() string {
 	return `function Take {
-	param([string]$Path)
-	if (-not $Path) {
-		Write-Error "Usage: Take <directory or git-url>"
+	if (-not $args) {
+		Write-Error "Usage: Take [options] <directory|git-url|archive-url> [dest]"
 		return
 	}
-	$result = take-cli $Path
+	$result = take-cli @args
 	if ($LASTEXITCODE -eq 0) {
 		Set-Location $result
 	} else {
@@ -174,6 +174,11 @@ func TestShellImplementations(t *testing.T) {
 			if !strings.Contains(script, "take") {
 				t.Error("SetupScript() missing 'take' function/alias")
 			}
+
+			// Options and the destination reach take-cli along with the path
+			if !strings.Contains(script, `take-cli "$@"`) && !strings.Contains(script, "take-cli @args") && !strings.Contains(script, "take-cli $*") {
+				t.Error("SetupScript() doesn't forward all arguments to take-cli")
+			}
 		})
 	}
 }
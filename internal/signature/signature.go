@@ -0,0 +1,488 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"aead.dev/minisign"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUntrustedKey     = errors.New("signature made by an untrusted key")
+	ErrInvalidKey       = errors.New("invalid trusted key")
+)
+
+// Formats of detached signatures
+const (
+	FormatMinisign = "minisign"
+	FormatSSH      = "ssh"
+)
+
+// SSHNamespace is the namespace of SSH file signatures, as made with
+// ssh-keygen -Y sign -n file
+const SSHNamespace = "file"
+
+// Result describes a valid signature
+type Result struct {
+	// Format is FormatMinisign or FormatSSH
+	Format string
+	// KeyID identifies the key that made the signature: the hex key ID of
+	// a minisign key, or the SHA256 fingerprint of an SSH key
+	KeyID string
+	// Comment is the comment of the trusted key, such as the untrusted
+	// comment of a minisign public key file or the comment of an SSH key
+	Comment string
+	// TrustedComment is the signed comment of a minisign signature
+	TrustedComment string
+}
+
+// KeySet holds the public keys signatures are trusted from
+type KeySet struct {
+	minisign []trustedMinisignKey
+	ssh      []trustedSSHKey
+}
+
+type trustedMinisignKey struct {
+	key     minisign.PublicKey
+	comment string
+}
+
+type trustedSSHKey struct {
+	key     ssh.PublicKey
+	comment string
+	// namespaces is the pattern list of the namespaces the key may sign
+	// for, empty for any
+	namespaces string
+	// validAfter and validBefore bound when the key is trusted, zero for
+	// no bound
+	validAfter  time.Time
+	validBefore time.Time
+	// certAuthority marks keys that are only trusted to sign certificates
+	certAuthority bool
+}
+
+// Len returns the number of keys in the set
+func (k *KeySet) Len() int {
+	return len(k.minisign) + len(k.ssh)
+}
+
+// ParseKeys builds a key set from entries that are either keys, a minisign
+// public key or an SSH public key line, or files of such keys, one per
+// line. Files may be minisign .pub files, authorized_keys or
+// allowed_signers files. The namespaces, valid-after and valid-before
+// options of allowed_signers lines restrict their key, while cert-authority
+// keys are never trusted since certificate signatures aren't supported.
+func ParseKeys(entries []string) (*KeySet, error) {
+	keys := &KeySet{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := keys.add(entry, ""); err == nil {
+			continue
+		}
+
+		data, err := os.ReadFile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is neither a key nor a readable key file", ErrInvalidKey, entry)
+		}
+		if err := keys.addFile(string(data)); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidKey, entry, err)
+		}
+	}
+	return keys, nil
+}
+
+// addFile adds the keys of a key file. Minisign .pub files name their key
+// on the untrusted comment line before it.
+func (k *KeySet) addFile(content string) error {
+	comment := ""
+	found := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if c, ok := strings.CutPrefix(line, "untrusted comment:"); ok {
+			comment = strings.TrimSpace(c)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := k.add(line, comment); err != nil {
+			return err
+		}
+		comment = ""
+		found = true
+	}
+	if !found {
+		return errors.New("no keys found")
+	}
+	return nil
+}
+
+// add adds a single minisign or SSH key
+func (k *KeySet) add(line, comment string) error {
+	var key minisign.PublicKey
+	if err := key.UnmarshalText([]byte(line)); err == nil {
+		k.minisign = append(k.minisign, trustedMinisignKey{key: key, comment: comment})
+		return nil
+	}
+
+	sshKey, err := parseSSHKey(line)
+	if err != nil {
+		return err
+	}
+	k.ssh = append(k.ssh, sshKey)
+	return nil
+}
+
+// parseSSHKey parses an SSH public key line, or an allowed_signers line
+// where the key follows the principals and an optional field of options
+func parseSSHKey(line string) (trustedSSHKey, error) {
+	fields := splitFields(line)
+	for i := 0; i <= 2 && i+1 < len(fields); i++ {
+		key, ok := parsePublicKey(fields[i], fields[i+1])
+		if !ok {
+			continue
+		}
+
+		trusted := trustedSSHKey{key: key, comment: strings.Join(fields[i+2:], " ")}
+		if i == 2 {
+			for _, option := range splitOptions(fields[1]) {
+				if err := trusted.setOption(option); err != nil {
+					return trustedSSHKey{}, fmt.Errorf("%v in %q", err, line)
+				}
+			}
+		}
+		return trusted, nil
+	}
+	return trustedSSHKey{}, fmt.Errorf("unrecognized key %q", line)
+}
+
+// parsePublicKey parses the type and base64 blob of an SSH public key
+func parsePublicKey(keyType, blob string) (ssh.PublicKey, bool) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, false
+	}
+	key, err := ssh.ParsePublicKey(data)
+	if err != nil || key.Type() != keyType {
+		return nil, false
+	}
+	return key, true
+}
+
+// setOption applies an option of an allowed_signers line
+func (t *trustedSSHKey) setOption(option string) error {
+	name, value, _ := strings.Cut(option, "=")
+	value = strings.Trim(value, `"`)
+
+	var err error
+	switch strings.ToLower(name) {
+	case "cert-authority":
+		t.certAuthority = true
+	case "namespaces":
+		t.namespaces = value
+	case "valid-after":
+		t.validAfter, err = parseSignerTime(value)
+	case "valid-before":
+		t.validBefore, err = parseSignerTime(value)
+	default:
+		return fmt.Errorf("unsupported option %q", name)
+	}
+	return err
+}
+
+// parseSignerTime parses the YYYYMMDD[HHMM[SS]] times of allowed_signers
+// files, which are in local time unless they end with Z
+func parseSignerTime(value string) (time.Time, error) {
+	loc := time.Local
+	if v, ok := strings.CutSuffix(strings.ToUpper(value), "Z"); ok {
+		value, loc = v, time.UTC
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+// allows returns why the key can't be trusted for a signature in namespace
+// at the time now, or nil if it can
+func (t *trustedSSHKey) allows(namespace string, now time.Time) error {
+	fingerprint := ssh.FingerprintSHA256(t.key)
+	switch {
+	case t.certAuthority:
+		return fmt.Errorf("%w: SSH key %s only signs certificates, which aren't supported", ErrUntrustedKey, fingerprint)
+	case t.namespaces != "" && !matchPatternList(namespace, t.namespaces):
+		return fmt.Errorf("%w: SSH key %s is not trusted for namespace %q", ErrUntrustedKey, fingerprint, namespace)
+	case !t.validAfter.IsZero() && now.Before(t.validAfter):
+		return fmt.Errorf("%w: SSH key %s is only valid after %s", ErrUntrustedKey, fingerprint, t.validAfter.Format(time.RFC3339))
+	case !t.validBefore.IsZero() && now.After(t.validBefore):
+		return fmt.Errorf("%w: SSH key %s was only valid before %s", ErrUntrustedKey, fingerprint, t.validBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// matchPatternList reports whether s matches the comma-separated patterns
+// of list, none of the negated ones starting with "!" included
+func matchPatternList(s, list string) bool {
+	matched := false
+	for _, pattern := range strings.Split(list, ",") {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), s); !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// splitFields splits line at whitespace outside double quotes
+func splitFields(line string) []string {
+	var fields []string
+	quoted := false
+	start := -1
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			if start >= 0 {
+				fields = append(fields, line[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, line[start:])
+	}
+	return fields
+}
+
+// splitOptions splits a field of options at commas outside double quotes
+func splitOptions(field string) []string {
+	var options []string
+	quoted := false
+	start := 0
+	for i, r := range field {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			options = append(options, field[start:i])
+			start = i + 1
+		}
+	}
+	return append(options, field[start:])
+}
+
+// Verify checks the detached signature sig of the file at path, which must
+// be made by one of the trusted keys. Both minisign and SSH signatures are
+// recognized.
+func (k *KeySet) Verify(path string, sig []byte) (Result, error) {
+	if bytes.Contains(sig, []byte(sshSigBegin)) {
+		return k.verifySSH(path, sig)
+	}
+	return k.verifyMinisign(path, sig)
+}
+
+func (k *KeySet) verifyMinisign(path string, sig []byte) (Result, error) {
+	var s minisign.Signature
+	if err := s.UnmarshalText(sig); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	keyID := formatKeyID(s.KeyID)
+	for _, trusted := range k.minisign {
+		if trusted.key.ID() != s.KeyID {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return Result{}, err
+		}
+		defer file.Close()
+
+		var valid bool
+		if s.Algorithm == minisign.HashEdDSA {
+			r := minisign.NewReader(file)
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				return Result{}, err
+			}
+			valid = r.Verify(trusted.key, sig)
+		} else {
+			// Legacy signatures cover the file itself rather than its hash
+			message, err := io.ReadAll(file)
+			if err != nil {
+				return Result{}, err
+			}
+			valid = minisign.Verify(trusted.key, message, sig)
+		}
+		if !valid {
+			return Result{}, fmt.Errorf("%w: minisign signature by key %s doesn't match", ErrInvalidSignature, keyID)
+		}
+
+		return Result{
+			Format:         FormatMinisign,
+			KeyID:          keyID,
+			Comment:        trusted.comment,
+			TrustedComment: s.TrustedComment,
+		}, nil
+	}
+	return Result{}, fmt.Errorf("%w: minisign key %s", ErrUntrustedKey, keyID)
+}
+
+// formatKeyID writes a minisign key ID the way minisign shows it
+func formatKeyID(id uint64) string {
+	return fmt.Sprintf("%016X", id)
+}
+
+const (
+	sshSigBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd   = "-----END SSH SIGNATURE-----"
+	sshSigMagic = "SSHSIG"
+)
+
+// sshSignature is the blob of an armored SSH signature, per the
+// PROTOCOL.sshsig file of OpenSSH
+type sshSignature struct {
+	Magic         [6]byte
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what an SSH signature actually signs
+type sshSignedData struct {
+	Magic         [6]byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func (k *KeySet) verifySSH(path string, sig []byte) (Result, error) {
+	text := string(sig)
+	armored := text[strings.Index(text, sshSigBegin)+len(sshSigBegin):]
+	end := strings.Index(armored, sshSigEnd)
+	if end < 0 {
+		return Result{}, fmt.Errorf("%w: unterminated SSH signature", ErrInvalidSignature)
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored[:end]), ""))
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	var s sshSignature
+	if err := ssh.Unmarshal(blob, &s); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if string(s.Magic[:]) != sshSigMagic || s.Version != 1 {
+		return Result{}, fmt.Errorf("%w: unsupported SSH signature version", ErrInvalidSignature)
+	}
+	if s.Namespace != SSHNamespace {
+		return Result{}, fmt.Errorf("%w: SSH signature for namespace %q, want %q", ErrInvalidSignature, s.Namespace, SSHNamespace)
+	}
+
+	signer, err := ssh.ParsePublicKey(s.PublicKey)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer)
+
+	// The same key may be listed several times with different options
+	var trusted *trustedSSHKey
+	err = fmt.Errorf("%w: SSH key %s", ErrUntrustedKey, fingerprint)
+	now := time.Now()
+	for i := range k.ssh {
+		if !bytes.Equal(k.ssh[i].key.Marshal(), signer.Marshal()) {
+			continue
+		}
+		if err = k.ssh[i].allows(s.Namespace, now); err == nil {
+			trusted = &k.ssh[i]
+			break
+		}
+	}
+	if trusted == nil {
+		return Result{}, err
+	}
+
+	var h hash.Hash
+	switch s.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return Result{}, fmt.Errorf("%w: unsupported hash algorithm %q", ErrInvalidSignature, s.HashAlgorithm)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return Result{}, err
+	}
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(s.Signature, &signature); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	// Like OpenSSH, refuse RSA signatures over SHA-1
+	if signature.Format == ssh.KeyAlgoRSA {
+		return Result{}, fmt.Errorf("%w: SHA-1 RSA signatures are not accepted", ErrInvalidSignature)
+	}
+	signed := ssh.Marshal(sshSignedData{
+		Magic:         s.Magic,
+		Namespace:     s.Namespace,
+		Reserved:      s.Reserved,
+		HashAlgorithm: s.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})
+	if err := trusted.key.Verify(signed, &signature); err != nil {
+		return Result{}, fmt.Errorf("%w: SSH signature by key %s doesn't match", ErrInvalidSignature, fingerprint)
+	}
+
+	return Result{
+		Format:  FormatSSH,
+		KeyID:   fingerprint,
+		Comment: trusted.comment,
+	}, nil
+}
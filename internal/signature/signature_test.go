@@ -0,0 +1,328 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aead.dev/minisign"
+)
+
+// SSH signatures of "take\n", made with ssh-keygen -Y sign by sshKey
+const (
+	sshKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAl4kSIQHx9o4pssbpJzJzJohIlquwCE38sFcxWJjvbJ release@example.com"
+	otherKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEsJtPLKqUkKo84hkT+IjSmAKEpF4ZnHaYzsCLd6fVAj other"
+	rsaKey   = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCylow+bnFn99bgToWGd1V9piKai0xRLjXXWe51RHpS/oELTEqdRf62i78jVt+Tm84jHxnuYZc07iR+WXQHNgV9STLB5fsq8F4cr/yRQCQbBVyOdPChO+tPIToAFGv9sUFfWdDiaVpzMn3LCh22h3R24wiBQwidf2Y6crnybBLFxz4WZJ53eWxhvykFdpiFLgBSW+QO6N2AsTEI/lPgSrYmKTmet58/M6U825eB6loCvmDuLgf6tzdeHCE2DTZPZayWqhmsqMjAKsI5Oh4gHfEJsgto9/pEV6Nb2rqUNlkXi2cUNFrwSyr6e6zxj0p99dUiwwY9nDOmb1Dn/MbZxz5R rsa"
+
+	sshKeyFingerprint = "SHA256:IPhgJQSFEM/s1BOTEEuUYG7QXzQuJGrC2CcC2uTEfM0"
+
+	sshSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgCXiRIhAfH2jimyxuknMnMmiEiW
+q7AITfywVzFYmO9skAAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAEBOiAplEaqNpiGczHoVySfc6lp1UzU4/jv6fnj2H5+3ezaIqBQVqiUnN1PWxwNKIx
+ynj2RoVCBjQso62PPpIngM
+-----END SSH SIGNATURE-----
+`
+	// sshGitSig was made for the git namespace
+	sshGitSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgCXiRIhAfH2jimyxuknMnMmiEiW
+q7AITfywVzFYmO9skAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQIFLdue1sMvpple3dAJxtkDB7EOsvS5umgTIrM5/ESVzN4Ysi3S+DuXR+LRLvSXiIw
+CWFFTSFu9btuZtS7TPtwI=
+-----END SSH SIGNATURE-----
+`
+	rsaSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAARcAAAAHc3NoLXJzYQAAAAMBAAEAAAEBALKWjD5ucWf31uBOhYZ3VX
+2mIpqLTFEuNddZ7nVEelL+gQtMSp1F/raLvyNW35ObziMfGe5hlzTuJH5ZdAc2BX1JMsHl
++yrwXhyv/JFAJBsFXI508KE7608hOgAUa/2xQV9Z0OJpWnMyfcsKHbaHdHbjCIFDCJ1/Zj
+pyufJsEsXHPhZknnd5bGG/KQV2mIUuAFJb5A7o3YCxMQj+U+BKtiYpOZ63nz8zpTzbl4Hq
+WgK+YO4uB/q3N14cITYNNk9lrJaqGayoyMAqwjk6HiAd8QmyC2j3+kRXo1vaupQ2WReLZx
+Q0WvBLKvp7rPGPSn311SLDBj2cM6ZvUOf8xtnHPlEAAAAEZmlsZQAAAAAAAAAGc2hhMjU2
+AAABFAAAAAxyc2Etc2hhMi01MTIAAAEAi53c8pfRSAFjQ+Jl9X+eYox6jv0XzA+f7Z63+W
+XmxVadci1K21OUHunVePgTymxiDJCa5Twcn1piPQOYWxsLjvV/37//aYLXILjlW01XyLU3
+uVv0me6joFx7uPK/352qbmkZ+NywXAtR0nqiwz4C4hMzWSUhVkvdXW0BJPIWaH1hSo7DLY
+wMgZfHqGsk8s8q4nTH7ea7Pqlrz1o1svgZqjDb4x7OmfhPeFnZMkCtLcDEUI/ZpNNmT2Ah
+1NmGg5eiN1ZY1uhcVQw1DU36JhBKgXRnxclq77YvGQuhUvJxvO7VaQvlqqmuwvLSpHIi3m
+ZwtKBviDj8t/pIJpexEBtGvw==
+-----END SSH SIGNATURE-----
+`
+)
+
+func TestVerify(t *testing.T) {
+	content := []byte("take\n")
+
+	pub, priv, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minisignKey := minisignKeyLine(t, pub)
+	otherMinisignKey := minisignKeyLine(t, otherPub)
+	r := minisign.NewReader(bytes.NewReader(content))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	hashedSig := r.SignWithComments(priv, "timestamp:1700000000\tfile:take.txt", "signature from take")
+	legacySig := minisign.SignWithComments(priv, content, "timestamp:1700000000", "signature from take")
+
+	tests := []struct {
+		name    string
+		keys    []string
+		content []byte
+		sig     string
+		want    Result
+		wantErr error
+	}{
+		{
+			name: "minisign signature",
+			keys: []string{minisignKey},
+			sig:  string(hashedSig),
+			want: Result{
+				Format:         FormatMinisign,
+				KeyID:          formatKeyID(pub.ID()),
+				TrustedComment: "timestamp:1700000000\tfile:take.txt",
+			},
+		},
+		{
+			name: "legacy minisign signature",
+			keys: []string{minisignKey},
+			sig:  string(legacySig),
+			want: Result{Format: FormatMinisign, KeyID: formatKeyID(pub.ID()), TrustedComment: "timestamp:1700000000"},
+		},
+		{
+			name:    "minisign signature by untrusted key",
+			keys:    []string{otherMinisignKey, sshKey},
+			sig:     string(hashedSig),
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name:    "tampered file with minisign signature",
+			keys:    []string{minisignKey},
+			content: []byte("tampered\n"),
+			sig:     string(hashedSig),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "ssh signature",
+			keys: []string{otherKey, sshKey},
+			sig:  sshSig,
+			want: Result{Format: FormatSSH, KeyID: sshKeyFingerprint, Comment: "release@example.com"},
+		},
+		{
+			name: "ssh rsa signature",
+			keys: []string{rsaKey},
+			sig:  rsaSig,
+			want: Result{Format: FormatSSH, KeyID: "", Comment: "rsa"},
+		},
+		{
+			name:    "ssh signature by untrusted key",
+			keys:    []string{otherKey, minisignKey},
+			sig:     sshSig,
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name: "allowed signer with options",
+			keys: []string{`release@example.com namespaces="file,git",valid-after="20000101",valid-before="29991231Z" ` + sshKey},
+			sig:  sshSig,
+			want: Result{Format: FormatSSH, KeyID: sshKeyFingerprint, Comment: "release@example.com"},
+		},
+		{
+			name:    "allowed signer for another namespace",
+			keys:    []string{`release@example.com namespaces="git" ` + sshKey},
+			sig:     sshSig,
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name: "allowed signer listed again for the namespace",
+			keys: []string{`release@example.com namespaces="git" ` + sshKey, `release@example.com namespaces="f*,!git" ` + sshKey},
+			sig:  sshSig,
+			want: Result{Format: FormatSSH, KeyID: sshKeyFingerprint, Comment: "release@example.com"},
+		},
+		{
+			name:    "expired allowed signer",
+			keys:    []string{`release@example.com valid-before="200001011200" ` + sshKey},
+			sig:     sshSig,
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name:    "allowed signer not yet valid",
+			keys:    []string{`release@example.com valid-after="29990101" ` + sshKey},
+			sig:     sshSig,
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name:    "certificate authority signature",
+			keys:    []string{"* cert-authority " + sshKey},
+			sig:     sshSig,
+			wantErr: ErrUntrustedKey,
+		},
+		{
+			name:    "ssh signature for another namespace",
+			keys:    []string{sshKey},
+			sig:     sshGitSig,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered file with ssh signature",
+			keys:    []string{sshKey},
+			content: []byte("tampered\n"),
+			sig:     sshSig,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "truncated ssh signature",
+			keys:    []string{sshKey},
+			sig:     sshSig[:len(sshSig)/2],
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "ssh signature ending inside its begin marker",
+			keys:    []string{sshKey},
+			sig:     "-----BEGIN SSH SIGNATURE-----END SSH SIGNATURE-----\n",
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "garbage signature",
+			keys:    []string{sshKey},
+			sig:     "not a signature",
+			wantErr: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "take.txt")
+			data := content
+			if tt.content != nil {
+				data = tt.content
+			}
+			if err := os.WriteFile(file, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := ParseKeys(tt.keys)
+			if err != nil {
+				t.Fatalf("ParseKeys() error = %v", err)
+			}
+			got, err := keys.Verify(file, []byte(tt.sig))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if tt.want.KeyID == "" {
+				// The fingerprint is checked for the ed25519 key only
+				tt.want.KeyID = got.KeyID
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	pub, _, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minisignKey := minisignKeyLine(t, pub)
+
+	dir := t.TempDir()
+	allowedSigners := "# release keys\nrelease@example.com " + sshKey + "\n\n" + otherKey + "\n" +
+		`"ops team",*@example.com namespaces="file" ` + otherKey + "\n* cert-authority " + sshKey + "\n"
+	files := map[string]string{
+		"minisign.pub":    "untrusted comment: minisign public key of the release team\n" + minisignKey + "\n",
+		"allowed_signers": allowedSigners,
+		"bad_option":      `release@example.com no-such-option ` + sshKey + "\n",
+		"invalid.pub":     "untrusted comment: nothing\nnot a key\n",
+		"empty.pub":       "# no keys\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		entries []string
+		wantLen int
+		wantErr error
+	}{
+		{
+			name:    "inline keys",
+			entries: []string{minisignKey, sshKey, " "},
+			wantLen: 2,
+		},
+		{
+			name:    "key files",
+			entries: []string{filepath.Join(dir, "minisign.pub"), filepath.Join(dir, "allowed_signers")},
+			wantLen: 5,
+		},
+		{
+			name:    "unsupported allowed signer option",
+			entries: []string{filepath.Join(dir, "bad_option")},
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "invalid key",
+			entries: []string{"RWQnotakey"},
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "invalid key file",
+			entries: []string{filepath.Join(dir, "invalid.pub")},
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "key file without keys",
+			entries: []string{filepath.Join(dir, "empty.pub")},
+			wantErr: ErrInvalidKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.entries)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseKeys() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && keys.Len() != tt.wantLen {
+				t.Errorf("ParseKeys() has %d keys, want %d", keys.Len(), tt.wantLen)
+			}
+		})
+	}
+
+	t.Run("minisign key comment", func(t *testing.T) {
+		keys, err := ParseKeys([]string{filepath.Join(dir, "minisign.pub")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keys.minisign[0].comment; got != "minisign public key of the release team" {
+			t.Errorf("comment = %q", got)
+		}
+	})
+}
+
+// minisignKeyLine returns the base64 line of a minisign public key, without
+// the comment line of .pub files
+func minisignKeyLine(t *testing.T, key minisign.PublicKey) string {
+	t.Helper()
+	text, err := key.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,171 @@
+package take
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/deblasis/take/internal/cache"
+)
+
+// Cache is the store of downloaded files, kept by their SHA-256
+type Cache = cache.Cache
+
+// CacheEntry describes the file cached for a URL
+type CacheEntry = cache.Entry
+
+// PruneOptions selects the cached files Cache.Prune removes
+type PruneOptions = cache.PruneOptions
+
+// OpenCache returns the download cache in the CacheDir option
+func OpenCache(opts Options) (*Cache, error) {
+	dir, err := cacheDir(opts)
+	if err != nil {
+		return nil, err
+	}
+	return cache.New(dir), nil
+}
+
+// fetchDownload places the file at url in dst and returns its headers. The
+// cached copy is used when a SHA-256 Checksum option names it, or when the
+// server answers that it didn't change; otherwise the file is downloaded
+// and cached. With the Offline option only the cache is used. It reports
+// whether the file came from the cache. A downloaded file that check, when
+// given, rejects fails the download and is not cached.
+func fetchDownload(ctx context.Context, opts Options, url, dst string, check func(path string) error) (http.Header, bool, error) {
+	c, err := OpenCache(opts)
+	if err != nil {
+		if opts.Offline {
+			return nil, false, fmt.Errorf("%w: %v", ErrOffline, err)
+		}
+		header, err := downloadFile(ctx, opts, url, dst)
+		return header, false, err
+	}
+	entry, lookupErr := c.Lookup(url)
+
+	// A checksum names the content, whatever URL it was downloaded from
+	if sum, ok := cacheChecksum(opts); ok && c.Has(sum) && c.CopyTo(sum, dst) == nil {
+		if entry.SHA256 != sum {
+			entry = cache.Entry{}
+		}
+		return cachedHeader(entry), true, nil
+	}
+
+	if opts.Offline {
+		if lookupErr != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrOffline, lookupErr)
+		}
+		if err := c.CopyTo(entry.SHA256, dst); err != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrOffline, err)
+		}
+		return cachedHeader(entry), true, nil
+	}
+
+	var cached *cache.Entry
+	if lookupErr == nil {
+		cached = &entry
+	}
+
+	// The download is received in the cache, to be moved into place rather
+	// than copied. A cache that can't be written doesn't fail the download.
+	received, err := c.TempFile()
+	if err != nil {
+		received = dst
+	} else {
+		defer os.Remove(received)
+	}
+
+	header, err := downloadIfModified(ctx, opts, url, received, cached)
+	if errors.Is(err, errNotModified) {
+		if c.CopyTo(entry.SHA256, dst) == nil {
+			return cachedHeader(entry), true, nil
+		}
+		// The cached copy was pruned in the meantime
+		header, err = downloadFile(ctx, opts, url, received)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if check != nil {
+		if err := check(received); err != nil {
+			return nil, false, err
+		}
+	}
+	if received == dst {
+		return header, false, nil
+	}
+
+	stored, err := c.Store(cache.Entry{
+		URL:                url,
+		ETag:               header.Get("ETag"),
+		LastModified:       header.Get("Last-Modified"),
+		ContentType:        header.Get("Content-Type"),
+		ContentDisposition: header.Get("Content-Disposition"),
+	}, received)
+	if err != nil {
+		err = moveFile(received, dst)
+	} else {
+		err = c.CopyTo(stored.SHA256, dst)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return header, false, nil
+}
+
+// fetchCachedSidecar returns a checksum or signature file published next to
+// an archive, from the cache with the Offline option, and caches the ones
+// fetched from the server. An empty content means the file is missing.
+func fetchCachedSidecar(ctx context.Context, opts Options, rawURL string) (string, error) {
+	c, err := OpenCache(opts)
+	if opts.Offline {
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrOffline, err)
+		}
+		entry, err := c.Lookup(rawURL)
+		if err != nil {
+			// Only the files found online are cached
+			return "", nil
+		}
+		content, err := c.ReadFile(entry.SHA256)
+		return string(content), err
+	}
+
+	content, fetchErr := fetchSidecarFile(ctx, opts, rawURL)
+	if fetchErr == nil && content != "" && err == nil {
+		c.Put(cache.Entry{URL: rawURL}, bytes.NewReader([]byte(content)))
+	}
+	return content, fetchErr
+}
+
+// cacheChecksum returns the SHA-256 digest of the Checksum option, which
+// names a file in the cache
+func cacheChecksum(opts Options) (string, bool) {
+	if opts.Checksum == "" {
+		return "", false
+	}
+	c, err := parseChecksum(opts.Checksum)
+	if err != nil || c.algorithm != "sha256" {
+		return "", false
+	}
+	return c.digest, true
+}
+
+// cachedHeader returns the response headers stored with a cache entry
+func cachedHeader(entry cache.Entry) http.Header {
+	header := make(http.Header)
+	for name, value := range map[string]string{
+		"ETag":                entry.ETag,
+		"Last-Modified":       entry.LastModified,
+		"Content-Type":        entry.ContentType,
+		"Content-Disposition": entry.ContentDisposition,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	return header
+}
@@ -0,0 +1,289 @@
+package take
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deblasis/take/internal/git"
+)
+
+func TestFetchDownload(t *testing.T) {
+	content := []byte("release 1.0")
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name string
+		// etag of the served file, changed for the second fetch
+		etag, secondETag string
+		// second are the options of the second fetch
+		second       Options
+		secondPath   string
+		closeServer  bool
+		wantBodies   int
+		wantRequests int
+		wantCached   bool
+		wantErr      error
+	}{
+		{
+			name:         "not modified",
+			etag:         `"v1"`,
+			secondETag:   `"v1"`,
+			wantBodies:   1,
+			wantRequests: 2,
+			wantCached:   true,
+		},
+		{
+			name:         "modified",
+			etag:         `"v1"`,
+			secondETag:   `"v2"`,
+			wantBodies:   2,
+			wantRequests: 2,
+		},
+		{
+			name:         "checksum from another URL",
+			second:       Options{Checksum: checksum},
+			secondPath:   "/mirror/release.tar.gz",
+			wantBodies:   1,
+			wantRequests: 1,
+			wantCached:   true,
+		},
+		{
+			name:         "offline",
+			etag:         `"v1"`,
+			second:       Options{Offline: true},
+			closeServer:  true,
+			wantBodies:   1,
+			wantRequests: 1,
+			wantCached:   true,
+		},
+		{
+			name:         "offline miss",
+			second:       Options{Offline: true},
+			secondPath:   "/other.tar.gz",
+			wantBodies:   1,
+			wantRequests: 1,
+			wantErr:      ErrOffline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			etag := tt.etag
+			requests, bodies := 0, 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+					if r.Header.Get("If-None-Match") == etag {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				bodies++
+				w.Write(content)
+			}))
+			defer ts.Close()
+
+			cacheDir := t.TempDir()
+			opts := Options{CacheDir: cacheDir, Retry: RetryPolicy{Attempts: 1}}
+			dst := filepath.Join(t.TempDir(), "first")
+			if _, fromCache, err := fetchDownload(context.Background(), opts, ts.URL+"/release.tar.gz", dst, nil); err != nil || fromCache {
+				t.Fatalf("fetchDownload() = cached %v, error %v, want a download", fromCache, err)
+			}
+
+			etag = tt.secondETag
+			if tt.closeServer {
+				ts.Close()
+			}
+			second := tt.second
+			second.CacheDir, second.Retry = cacheDir, opts.Retry
+			secondPath := tt.secondPath
+			if secondPath == "" {
+				secondPath = "/release.tar.gz"
+			}
+			dst = filepath.Join(t.TempDir(), "second")
+			_, fromCache, err := fetchDownload(context.Background(), second, ts.URL+secondPath, dst, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("fetchDownload() error = %v, want %v", err, tt.wantErr)
+			}
+			if fromCache != tt.wantCached {
+				t.Errorf("fetchDownload() cached = %v, want %v", fromCache, tt.wantCached)
+			}
+			if requests != tt.wantRequests || bodies != tt.wantBodies {
+				t.Errorf("server got %d requests and sent %d bodies, want %d and %d", requests, bodies, tt.wantRequests, tt.wantBodies)
+			}
+			if err != nil {
+				return
+			}
+			if got, err := os.ReadFile(dst); err != nil || string(got) != string(content) {
+				t.Errorf("fetched %q (%v), want %q", got, err, content)
+			}
+		})
+	}
+}
+
+func TestFetchDownloadBusy(t *testing.T) {
+	content := []byte("release 1.0")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(content)
+	}))
+	defer ts.Close()
+	url := ts.URL + "/release.tar.gz"
+
+	// Another take is downloading the same URL into its partial file
+	opts := Options{CacheDir: t.TempDir(), Retry: RetryPolicy{Attempts: 1}}
+	c, err := OpenCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := c.LockPartial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	partPath, err := c.PartialPath(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partPath, []byte("rele"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "release.tar.gz")
+	if _, _, err := fetchDownload(context.Background(), opts, url, dst, nil); err != nil {
+		t.Fatalf("fetchDownload() error = %v", err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != string(content) {
+		t.Errorf("fetchDownload() wrote %q (%v), want %q", got, err, content)
+	}
+	if got, _ := os.ReadFile(partPath); string(got) != "rele" {
+		t.Errorf("partial download = %q, want it left to its take", got)
+	}
+
+	// The download was moved into the cache, leaving nothing behind
+	entry, err := c.Lookup(url)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	blobs, _ := os.ReadDir(filepath.Join(c.Dir(), "downloads", "blobs"))
+	if len(blobs) != 1 || blobs[0].Name() != entry.SHA256 {
+		t.Errorf("cache blobs = %v, want only %s", blobs, entry.SHA256)
+	}
+}
+
+func TestTakeOffline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("offline take requested %s", r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{
+			name: "browser tree URL",
+			opts: Options{Path: ts.URL + "/user/repo/tree/feature/x/docs"},
+		},
+		{
+			name: "browser tree URL as a template",
+			opts: Options{Path: ts.URL + "/user/repo/tree/feature/x/docs", Template: true},
+		},
+		{
+			// Reached as localhost, the server isn't a known forge
+			name: "repository without a .git suffix",
+			opts: Options{Path: strings.Replace(ts.URL, "127.0.0.1", "localhost", 1) + "/other/repo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := tt.opts
+			opts.Offline = true
+			opts.CacheDir = filepath.Join(dir, "cache")
+			opts.CloneRoot = filepath.Join(dir, "src")
+			opts.TargetDir = filepath.Join(dir, "target")
+			opts.Forges = []Forge{{Prefix: "local", Host: host, Type: git.ForgeGitHub}}
+			if result := TakeContext(context.Background(), opts); !errors.Is(result.Error, ErrOffline) {
+				t.Errorf("Take() error = %v, want %v", result.Error, ErrOffline)
+			}
+		})
+	}
+}
+
+func TestTakeOfflineReuse(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo)
+
+	dir := t.TempDir()
+	opts := Options{
+		Path:      repo,
+		TargetDir: filepath.Join(dir, "clone"),
+		CacheDir:  filepath.Join(dir, "cache"),
+	}
+	if result := TakeContext(context.Background(), opts); result.Error != nil {
+		t.Fatalf("Take() error = %v", result.Error)
+	}
+
+	// The tag only exists in the origin
+	cmd := exec.Command("git", "tag", "v2")
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git tag failed: %v\n%s", err, out)
+	}
+
+	opts.Offline = true
+	opts.Ref = "v2"
+	if result := TakeContext(context.Background(), opts); !errors.Is(result.Error, ErrOffline) {
+		t.Errorf("Take() error = %v, want %v", result.Error, ErrOffline)
+	}
+
+	cmd = exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = opts.TargetDir
+	head, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git rev-parse failed: %v", err)
+	}
+	opts.Ref = strings.TrimSpace(string(head))
+	if result := TakeContext(context.Background(), opts); result.Error != nil || !result.WasReused {
+		t.Errorf("Take() = %+v, want the clone reused at a local commit", result)
+	}
+}
+
+func TestTakeOfflineForgeURL(t *testing.T) {
+	// A forge URL without .git is recognized without asking the server,
+	// so an existing clone is reused offline
+	dir := filepath.Join(t.TempDir(), "repo")
+	for _, args := range [][]string{
+		{"init", "--quiet", dir},
+		{"-C", dir, "remote", "add", "origin", "git@github.com:user/repo.git"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+
+	result := TakeContext(context.Background(), Options{
+		Path:      "git@github.com:user/repo",
+		TargetDir: dir,
+		CacheDir:  filepath.Join(t.TempDir(), "cache"),
+		Offline:   true,
+	})
+	if result.Error != nil || !result.WasReused {
+		t.Errorf("Take() = %+v, want the clone reused", result)
+	}
+}
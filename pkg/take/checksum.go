@@ -0,0 +1,250 @@
+package take
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/deblasis/take/internal/retry"
+)
+
+// maxSidecarFileSize bounds the checksum and signature files read next to
+// an archive
+const maxSidecarFileSize = 1 << 20
+
+// checksumAlgorithms are the supported digests, by name and hex length
+var checksumAlgorithms = []struct {
+	name    string
+	hexLen  int
+	newHash func() hash.Hash
+	// sumsFile lists the checksums of a release, as written by sha256sum
+	sumsFile string
+}{
+	{"sha256", 64, sha256.New, "SHA256SUMS"},
+	{"sha512", 128, sha512.New, "SHA512SUMS"},
+}
+
+// checksum is the expected digest of a file
+type checksum struct {
+	algorithm string
+	// digest is lowercase hex
+	digest string
+}
+
+// parseChecksum reads a checksum given as "sha256:<hex>", "sha512:<hex>"
+// or bare hex, whose length tells the algorithm
+func parseChecksum(s string) (checksum, error) {
+	algorithm, digest, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		algorithm, digest = "", algorithm
+	}
+	digest = strings.ToLower(digest)
+
+	for _, a := range checksumAlgorithms {
+		if (algorithm == "" || strings.EqualFold(algorithm, a.name)) && len(digest) == a.hexLen {
+			if _, err := hex.DecodeString(digest); err == nil {
+				return checksum{algorithm: a.name, digest: digest}, nil
+			}
+		}
+	}
+	return checksum{}, fmt.Errorf("invalid checksum %q: use sha256:<hex> or sha512:<hex>", s)
+}
+
+// verify compares the digest of file with c
+func (c checksum) verify(file string) error {
+	var h hash.Hash
+	for _, a := range checksumAlgorithms {
+		if a.name == c.algorithm {
+			h = a.newHash()
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != c.digest {
+		return fmt.Errorf("%w: %s: expected %s %s, got %s", ErrChecksumMismatch, filepath.Base(file), c.algorithm, c.digest, got)
+	}
+	return nil
+}
+
+// verifyDownload checks file, downloaded from rawURL under name, against
+// the Checksum option, or with VerifyChecksum the checksum published next to
+// the URL
+func verifyDownload(ctx context.Context, opts Options, rawURL, name, file string) error {
+	return verify(opts, file, func() (checksum, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return checksum{}, err
+		}
+		return lookupChecksum(path.Base(u.Path), name, func(sumsFile string) (string, error) {
+			return fetchCachedSidecar(ctx, opts, sidecarURL(u, sumsFile))
+		})
+	})
+}
+
+// verifyFile checks a local file against the Checksum option, or with
+// VerifyChecksum the checksum files next to it
+func verifyFile(opts Options, file string) error {
+	return verify(opts, file, func() (checksum, error) {
+		name := filepath.Base(file)
+		return lookupChecksum(name, name, func(sumsFile string) (string, error) {
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(file), sumsFile))
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return string(data), err
+		})
+	})
+}
+
+// verify checks file against the Checksum option, or with VerifyChecksum
+// the checksum returned by lookup
+func verify(opts Options, file string, lookup func() (checksum, error)) error {
+	var sum checksum
+	var err error
+	switch {
+	case opts.Checksum != "":
+		sum, err = parseChecksum(opts.Checksum)
+	case opts.VerifyChecksum:
+		sum, err = lookup()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return sum.verify(file)
+}
+
+// lookupChecksum finds the checksum of file in the checksum files published
+// next to it: <file>.sha256 and <file>.sha512, then SHA256SUMS and
+// SHA512SUMS. read returns the content of one of those, empty when it is
+// missing. Entries may also list the file under name, such as the name a
+// server gave a download.
+func lookupChecksum(file, name string, read func(sumsFile string) (string, error)) (checksum, error) {
+	names := []string{file}
+	if name != "" && name != file {
+		names = append(names, name)
+	}
+
+	for _, a := range checksumAlgorithms {
+		content, err := read(file + "." + a.name)
+		if err != nil {
+			return checksum{}, err
+		}
+		// A lone digest stands for the file it is named after
+		if digest, ok := findChecksum(content, names, true); ok && len(digest) == a.hexLen {
+			return checksum{algorithm: a.name, digest: digest}, nil
+		}
+	}
+
+	for _, a := range checksumAlgorithms {
+		content, err := read(a.sumsFile)
+		if err != nil {
+			return checksum{}, err
+		}
+		if digest, ok := findChecksum(content, names, false); ok && len(digest) == a.hexLen {
+			return checksum{algorithm: a.name, digest: digest}, nil
+		}
+	}
+
+	return checksum{}, fmt.Errorf("%w: %s", ErrChecksumNotFound, file)
+}
+
+// findChecksum returns the digest listed for one of names in a checksum
+// file, in the "<hex>  <name>" format of sha256sum or the BSD
+// "SHA256 (<name>) = <hex>" format. With lone, a file holding nothing but a
+// digest matches too.
+func findChecksum(content string, names []string, lone bool) (string, bool) {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		var digest, file string
+		switch {
+		case len(fields) == 1 && lone && len(lines) == 1:
+			digest, file = fields[0], names[0]
+		case len(fields) == 2:
+			// A leading * marks files read in binary mode
+			digest, file = fields[0], strings.TrimPrefix(fields[1], "*")
+		case len(fields) == 4 && fields[2] == "=":
+			digest, file = fields[3], strings.TrimSuffix(strings.TrimPrefix(fields[1], "("), ")")
+		default:
+			continue
+		}
+
+		digest = strings.ToLower(digest)
+		if _, err := hex.DecodeString(digest); err != nil {
+			continue
+		}
+		if slices.Contains(names, strings.TrimPrefix(file, "./")) {
+			return digest, true
+		}
+	}
+	return "", false
+}
+
+// sidecarURL returns the URL of the file named name in the same directory
+// as u
+func sidecarURL(u *url.URL, name string) string {
+	sidecar := *u
+	sidecar.Path = path.Join(path.Dir(u.Path), name)
+	sidecar.RawPath = ""
+	sidecar.RawQuery = ""
+	return sidecar.String()
+}
+
+// fetchSidecarFile downloads a checksum or signature file published next to
+// an archive, returning an empty content when the server doesn't have it.
+// Servers answer missing files with various client errors, such as 403 for
+// private buckets, so those all count as missing.
+func fetchSidecarFile(ctx context.Context, opts Options, rawURL string) (string, error) {
+	var content string
+	err := opts.Retry.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient(opts).Do(req)
+		if err != nil {
+			return retryable(err)
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			data, err := io.ReadAll(io.LimitReader(resp.Body, maxSidecarFileSize))
+			if err != nil {
+				return retryable(err)
+			}
+			content = string(data)
+			return nil
+		case opts.Retry.RetryStatus(resp.StatusCode):
+			err := fmt.Errorf("%w: %s: %s", ErrDownloadFailed, rawURL, resp.Status)
+			return retry.Retryable(err, retry.RetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			return nil
+		default:
+			return fmt.Errorf("%w: %s: %s", ErrDownloadFailed, rawURL, resp.Status)
+		}
+	})
+	return content, err
+}
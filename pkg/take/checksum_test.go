@@ -0,0 +1,148 @@
+package take
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Digests of "take\n"
+const (
+	takeSHA256 = "6ac3a7f16406457ef9896e732a3b32c701b514cce13437173b06a2080ac910cb"
+	takeSHA512 = "ef782aea19000e7372213415e405dfc6e00382e81205606874498787013e53b1" +
+		"387abf7080d2009d017584885a5a3f4432af3dfeabdc94e086084b414de08d6d"
+)
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    checksum
+		wantErr bool
+	}{
+		{input: "sha256:" + takeSHA256, want: checksum{"sha256", takeSHA256}},
+		{input: "SHA256:" + strings.ToUpper(takeSHA256), want: checksum{"sha256", takeSHA256}},
+		{input: "sha512:" + takeSHA512, want: checksum{"sha512", takeSHA512}},
+		{input: takeSHA256, want: checksum{"sha256", takeSHA256}},
+		{input: takeSHA512, want: checksum{"sha512", takeSHA512}},
+		{input: "sha512:" + takeSHA256, wantErr: true},
+		{input: "md5:d41d8cd98f00b204e9800998ecf8427e", wantErr: true},
+		{input: "sha256:" + strings.Repeat("z", 64), wantErr: true},
+		{input: "abc123", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseChecksum(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseChecksum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "take.txt")
+	if err := os.WriteFile(file, []byte("take\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sum     checksum
+		wantErr error
+	}{
+		{"sha256", checksum{"sha256", takeSHA256}, nil},
+		{"sha512", checksum{"sha512", takeSHA512}, nil},
+		{"mismatch", checksum{"sha256", strings.Repeat("0", 64)}, ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sum.verify(file); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupChecksum(t *testing.T) {
+	sha256Hex := strings.Repeat("a", 64)
+	sha512Hex := strings.Repeat("b", 128)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		file  string
+		alias string
+		want  checksum
+	}{
+		{
+			name:  "sidecar with a lone digest",
+			files: map[string]string{"app.tar.gz.sha256": sha256Hex + "\n"},
+			file:  "app.tar.gz",
+			want:  checksum{"sha256", sha256Hex},
+		},
+		{
+			name:  "sidecar in sha256sum format",
+			files: map[string]string{"app.tar.gz.sha512": sha512Hex + "  app.tar.gz\n"},
+			file:  "app.tar.gz",
+			want:  checksum{"sha512", sha512Hex},
+		},
+		{
+			name: "sums file",
+			files: map[string]string{"SHA256SUMS": strings.Repeat("c", 64) + "  app.zip\n" +
+				sha256Hex + " *app.tar.gz\n"},
+			file: "app.tar.gz",
+			want: checksum{"sha256", sha256Hex},
+		},
+		{
+			name:  "BSD format",
+			files: map[string]string{"SHA512SUMS": "SHA512 (./app.tar.gz) = " + sha512Hex + "\n"},
+			file:  "app.tar.gz",
+			want:  checksum{"sha512", sha512Hex},
+		},
+		{
+			name:  "entry under the server's name",
+			files: map[string]string{"SHA256SUMS": sha256Hex + "  app-1.0.tar.gz\n"},
+			file:  "download",
+			alias: "app-1.0.tar.gz",
+			want:  checksum{"sha256", sha256Hex},
+		},
+		{
+			name:  "sums file without the file",
+			files: map[string]string{"SHA256SUMS": sha256Hex + "  other.tar.gz\n"},
+			file:  "app.tar.gz",
+		},
+		{
+			name:  "lone digest in a sums file",
+			files: map[string]string{"SHA256SUMS": sha256Hex + "\n"},
+			file:  "app.tar.gz",
+		},
+		{
+			name: "nothing published",
+			file: "app.tar.gz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupChecksum(tt.file, tt.alias, func(sumsFile string) (string, error) {
+				return tt.files[sumsFile], nil
+			})
+			if tt.want == (checksum{}) {
+				if !errors.Is(err, ErrChecksumNotFound) {
+					t.Errorf("lookupChecksum() error = %v, want %v", err, ErrChecksumNotFound)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("lookupChecksum() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
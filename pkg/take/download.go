@@ -0,0 +1,332 @@
+package take
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deblasis/take/internal/cache"
+	"github.com/deblasis/take/internal/retry"
+)
+
+// partialDownload describes an interrupted download, saved next to the data
+// received so far so that a later attempt can resume it
+type partialDownload struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the If-Range value that makes the server send the rest
+// of the file only if it didn't change: a strong ETag, else the modification
+// time. Downloads without one are not resumed.
+func (p partialDownload) validator() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// errNotModified reports that the server still has the content of a cached
+// download
+var errNotModified = errors.New("not modified")
+
+// downloadFile downloads a file from a URL to dst and returns the response
+// headers. Failures such as dropped connections or 503 responses are
+// retried under the Retry option.
+func downloadFile(ctx context.Context, opts Options, url, dst string) (http.Header, error) {
+	return downloadIfModified(ctx, opts, url, dst, nil)
+}
+
+// downloadIfModified downloads url to dst like downloadFile unless the
+// server answers that the cached copy of it is still current, which returns
+// errNotModified
+func downloadIfModified(ctx context.Context, opts Options, url, dst string, cached *cache.Entry) (http.Header, error) {
+	var header http.Header
+	err := opts.Retry.Do(ctx, func() error {
+		var err error
+		header, err = downloadAttempt(ctx, opts, url, dst, cached)
+		return err
+	})
+	return header, err
+}
+
+// downloadAttempt makes one try at downloading url to dst. The data is
+// received in the cache directory, where an interrupted download is kept
+// and resumed by the next attempt with a Range request, as long as the file
+// didn't change on the server. The transfer is reported to the Progress
+// option.
+func downloadAttempt(ctx context.Context, opts Options, url, dst string, cached *cache.Entry) (http.Header, error) {
+	partPath, release, resumable := partialPath(opts, url, dst)
+	defer release()
+	statePath := partPath + ".json"
+
+	state, offset := loadPartial(partPath, statePath, url)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Offsets must count the bytes of the file, not of a compressed transfer
+	req.Header.Set("Accept-Encoding", "identity")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", state.validator())
+	} else if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := httpClient(opts).Do(req)
+	if err != nil {
+		return nil, retryable(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return resp.Header, errNotModified
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent &&
+		rangeStart(resp.Header.Get("Content-Range")) == offset:
+		// The server sends the rest of the same file
+	case resp.StatusCode == http.StatusOK:
+		// A new download, or the file changed since the partial one
+		offset = 0
+		state = partialDownload{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+	case offset > 0 && (resp.StatusCode == http.StatusPartialContent ||
+		resp.StatusCode == http.StatusRequestedRangeNotSatisfiable):
+		// The partial download doesn't fit the file anymore, start over
+		removePartial(partPath, statePath)
+		release()
+		return downloadAttempt(ctx, opts, url, dst, cached)
+	case opts.Retry.RetryStatus(resp.StatusCode):
+		err := fmt.Errorf("%w: %s", ErrDownloadFailed, resp.Status)
+		return nil, retry.Retryable(err, retry.RetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrDownloadFailed, resp.Status)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only downloads the server can validate are kept for resuming
+	resumable = resumable && state.validator() != ""
+	if resumable {
+		resumable = savePartial(statePath, state) == nil
+	} else {
+		os.Remove(statePath)
+	}
+
+	err = copyBody(opts, file, resp, url, offset)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if !resumable {
+			removePartial(partPath, statePath)
+		}
+		return nil, retryable(err)
+	}
+
+	os.Remove(statePath)
+	if err := moveFile(partPath, dst); err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
+
+// httpClient returns the HTTPClient option, else http.DefaultClient
+func httpClient(opts Options) *http.Client {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// retryable marks errors of the connection to the server as worth
+// retrying: failures to connect, resets and responses cut short. Hosts that
+// don't exist are not retried.
+func retryable(err error) error {
+	cause := err
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// url.Error reports itself as a net.Error whatever the cause
+		cause = urlErr.Err
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(cause, &dnsErr) && dnsErr.IsNotFound {
+		return err
+	}
+	var netErr net.Error
+	if errors.As(cause, &netErr) || errors.Is(cause, io.EOF) || errors.Is(cause, io.ErrUnexpectedEOF) {
+		return retry.Retryable(err, 0)
+	}
+	return err
+}
+
+// copyBody writes the response body to file, reporting the transfer to the
+// Progress option. offset bytes were received by an earlier attempt.
+func copyBody(opts Options, file *os.File, resp *http.Response, url string, offset int64) error {
+	if opts.Progress == nil {
+		_, err := io.Copy(file, resp.Body)
+		return err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	body := newProgressReader(resp.Body, opts.Progress, Progress{
+		URL:        url,
+		Name:       downloadName(url, resp.Header),
+		Downloaded: offset,
+		Resumed:    offset,
+		Total:      total,
+	})
+	_, err := io.Copy(file, body)
+	body.finish()
+	return err
+}
+
+// cacheDir returns the directory take keeps downloads in: the CacheDir
+// option, else take in the user's cache directory
+func cacheDir(opts Options) (string, error) {
+	if opts.CacheDir != "" {
+		return expandPath(opts.CacheDir)
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "take"), nil
+}
+
+// partialPath returns where the download of url is received, a function to
+// call once it is done, and whether the file may be resumed. The partial
+// download kept in the cache is locked for this take, so that concurrent
+// takes of url never write into it; while another take holds it, the
+// download goes to a file of this take's own. Without a cache directory the
+// file is next to dst.
+func partialPath(opts Options, url, dst string) (path string, release func(), resumable bool) {
+	c, err := OpenCache(opts)
+	if err != nil {
+		return dst + ".part", func() {}, true
+	}
+	path, err = c.PartialPath(url)
+	if err != nil {
+		return dst + ".part", func() {}, true
+	}
+
+	unlock, err := c.LockPartial(url)
+	if err == nil {
+		return path, unlock, true
+	}
+	tmp, err := c.TempFile()
+	if err != nil {
+		return dst + ".part", func() {}, true
+	}
+	return tmp, func() { os.Remove(tmp) }, false
+}
+
+// loadPartial returns the state of an earlier download of url and the
+// number of bytes it received, zero when there is nothing to resume
+func loadPartial(partPath, statePath, url string) (partialDownload, int64) {
+	var state partialDownload
+	data, err := os.ReadFile(statePath)
+	if err != nil || json.Unmarshal(data, &state) != nil {
+		return partialDownload{}, 0
+	}
+	if state.URL != url || state.validator() == "" {
+		return partialDownload{}, 0
+	}
+
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return partialDownload{}, 0
+	}
+	return state, info.Size()
+}
+
+func savePartial(statePath string, state partialDownload) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, data, 0644)
+}
+
+func removePartial(partPath, statePath string) {
+	os.Remove(partPath)
+	os.Remove(statePath)
+}
+
+// rangeStart returns the first byte of a "bytes first-last/size"
+// Content-Range header, or -1 when it can't be parsed
+func rangeStart(contentRange string) int64 {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// moveFile renames src to dst, copying it when they are on different
+// filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,283 @@
+package take
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	changed := bytes.Repeat([]byte("abcdefghij"), 10000)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// Validators sent with the interrupted and the second response
+		firstETag  string
+		secondETag string
+		noModTime  bool
+		// secondContent is served by the second response
+		secondContent []byte
+		wantRange     bool
+	}{
+		{
+			name:          "resume with ETag",
+			firstETag:     `"v1"`,
+			secondETag:    `"v1"`,
+			secondContent: content,
+			wantRange:     true,
+		},
+		{
+			name:          "resume with Last-Modified",
+			secondContent: content,
+			wantRange:     true,
+		},
+		{
+			name:          "restart when the file changed",
+			firstETag:     `"v1"`,
+			secondETag:    `"v2"`,
+			secondContent: changed,
+			wantRange:     true,
+		},
+		{
+			name:          "restart without validator",
+			noModTime:     true,
+			secondContent: content,
+		},
+		{
+			name:          "restart with weak ETag",
+			firstETag:     `W/"v1"`,
+			secondETag:    `W/"v1"`,
+			noModTime:     true,
+			secondContent: content,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []*http.Request
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				if len(requests) == 1 {
+					// Drop the connection halfway through
+					if tt.firstETag != "" {
+						w.Header().Set("ETag", tt.firstETag)
+					}
+					if !tt.noModTime {
+						w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+					}
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content[:len(content)/2])
+					panic(http.ErrAbortHandler)
+				}
+
+				if tt.secondETag != "" {
+					w.Header().Set("ETag", tt.secondETag)
+				}
+				served := modTime
+				if tt.noModTime {
+					served = time.Time{}
+				}
+				http.ServeContent(w, r, "", served, bytes.NewReader(tt.secondContent))
+			}))
+			defer ts.Close()
+
+			// The interrupted download is left for the next call to resume
+			opts := Options{CacheDir: t.TempDir(), Retry: RetryPolicy{Attempts: 1}}
+			dst := filepath.Join(t.TempDir(), "download")
+
+			if _, err := downloadFile(context.Background(), opts, ts.URL+"/big.tar.gz", dst); err == nil {
+				t.Fatal("interrupted download succeeded")
+			}
+			if _, err := downloadFile(context.Background(), opts, ts.URL+"/big.tar.gz", dst); err != nil {
+				t.Fatalf("downloadFile() error = %v", err)
+			}
+
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.secondContent) {
+				t.Errorf("downloaded %d bytes that differ from the served file", len(got))
+			}
+			if gotRange := requests[1].Header.Get("Range") != ""; gotRange != tt.wantRange {
+				t.Errorf("second request Range = %q, want range %v", requests[1].Header.Get("Range"), tt.wantRange)
+			}
+
+			// Nothing is left to resume once the download completed
+			leftovers, _ := os.ReadDir(filepath.Join(opts.CacheDir, "partial"))
+			if len(leftovers) != 0 {
+				t.Errorf("partial downloads left in the cache: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	tests := []struct {
+		name string
+		// fail answers the first requests, nil afterwards
+		fail      []func(w http.ResponseWriter)
+		policy    RetryPolicy
+		wantErr   bool
+		wantCalls int
+		// wantRange is the Range header of the last request
+		wantRange string
+	}{
+		{
+			name: "service unavailable",
+			fail: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			wantCalls: 3,
+		},
+		{
+			name: "dropped connection resumed",
+			fail: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("ETag", `"v1"`)
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content[:1000])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				},
+			},
+			wantCalls: 2,
+			wantRange: "bytes=1000-",
+		},
+		{
+			name: "not found",
+			fail: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) },
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name: "status not retried by policy",
+			fail: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			},
+			policy:    RetryPolicy{StatusCodes: []int{http.StatusTooManyRequests}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name: "attempts exhausted",
+			fail: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+			},
+			policy:    RetryPolicy{Attempts: 2},
+			wantErr:   true,
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var lastRange string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				lastRange = r.Header.Get("Range")
+				if calls <= len(tt.fail) {
+					tt.fail[calls-1](w)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			policy := tt.policy
+			policy.Backoff = time.Millisecond
+			opts := Options{CacheDir: t.TempDir(), Retry: policy}
+			dst := filepath.Join(t.TempDir(), "download")
+
+			_, err := downloadFile(context.Background(), opts, ts.URL+"/file.tar.gz", dst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("downloadFile() made %d requests, want %d", calls, tt.wantCalls)
+			}
+			if lastRange != tt.wantRange {
+				t.Errorf("last request Range = %q, want %q", lastRange, tt.wantRange)
+			}
+			if err != nil {
+				return
+			}
+			if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, content) {
+				t.Errorf("downloaded file differs from the served one (%v)", err)
+			}
+		})
+	}
+}
+
+// roundTripFunc serves requests without a network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDownloadHTTPClient(t *testing.T) {
+	content := []byte("served by the injected client")
+	var requested []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        make(http.Header),
+			Body:          io.NopCloser(bytes.NewReader(content)),
+			ContentLength: int64(len(content)),
+			Request:       req,
+		}, nil
+	})}
+
+	opts := Options{HTTPClient: client, CacheDir: t.TempDir()}
+	dst := filepath.Join(t.TempDir(), "download")
+	if _, err := downloadFile(context.Background(), opts, "https://archive.invalid/file.bin", dst); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded %q (%v), want %q", got, err, content)
+	}
+	if len(requested) != 1 || requested[0] != "https://archive.invalid/file.bin" {
+		t.Errorf("client requested %v, want the download URL", requested)
+	}
+}
+
+func TestRangeStart(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 500-999/1000", 500},
+		{"bytes 0-0/*", 0},
+		{"bytes */1000", -1},
+		{"items 1-2/3", -1},
+		{"", -1},
+	}
+
+	for _, tt := range tests {
+		if got := rangeStart(tt.header); got != tt.want {
+			t.Errorf("rangeStart(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package take
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressInterval is the minimum time between two progress reports of a
+// download
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes how far a download has come
+type Progress struct {
+	// URL is the address being downloaded
+	URL string
+	// Name is the file name of the download
+	Name string
+	// Downloaded is the number of bytes received so far
+	Downloaded int64
+	// Resumed is the part of Downloaded received by an earlier, interrupted
+	// attempt
+	Resumed int64
+	// Total is the size announced by the server, or -1 when unknown
+	Total int64
+	// Elapsed is the time since the download started
+	Elapsed time.Duration
+	// Done is set on the last report of a download, whether it succeeded
+	// or not
+	Done bool
+}
+
+// progressReader reports the bytes read through it, at most once per
+// progressInterval
+type progressReader struct {
+	r        io.Reader
+	report   func(Progress)
+	progress Progress
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressReader(r io.Reader, report func(Progress), progress Progress) *progressReader {
+	now := time.Now()
+	pr := &progressReader{r: r, report: report, progress: progress, start: now, last: now}
+	pr.report(pr.progress)
+	return pr
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.progress.Downloaded += int64(n)
+
+	if now := time.Now(); now.Sub(pr.last) >= progressInterval {
+		pr.last = now
+		pr.progress.Elapsed = now.Sub(pr.start)
+		pr.report(pr.progress)
+	}
+	return n, err
+}
+
+// finish sends the last report of the download
+func (pr *progressReader) finish() {
+	pr.progress.Elapsed = time.Since(pr.start)
+	pr.progress.Done = true
+	pr.report(pr.progress)
+}
+
+// progressLogInterval is the time between two progress lines when the
+// output is not a terminal
+const progressLogInterval = 5 * time.Second
+
+// NewProgressPrinter returns a Progress callback that writes to w: a bar
+// redrawn in place when w is a terminal, and a plain line every few seconds
+// otherwise
+func NewProgressPrinter(w io.Writer) func(Progress) {
+	p := &progressPrinter{w: w, width: 80}
+	if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.tty = true
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+			p.width = width
+		}
+	}
+	return p.print
+}
+
+type progressPrinter struct {
+	w     io.Writer
+	tty   bool
+	width int
+	// last is the elapsed time of the last line logged
+	last time.Duration
+}
+
+func (p *progressPrinter) print(pr Progress) {
+	if p.tty {
+		// Redraw the line, and move on once the download is done
+		fmt.Fprintf(p.w, "\r%s\x1b[K", p.bar(pr))
+		if pr.Done {
+			fmt.Fprintln(p.w)
+		}
+		return
+	}
+
+	switch {
+	case pr.Done:
+		if pr.Total < 0 || pr.Downloaded == pr.Total {
+			fmt.Fprintf(p.w, "%s: %s in %s (%s/s)\n", pr.Name, FormatBytes(pr.Downloaded),
+				pr.Elapsed.Round(time.Second), FormatBytes(rate(pr)))
+		}
+	case pr.Elapsed == 0:
+		switch {
+		case pr.Resumed > 0:
+			fmt.Fprintf(p.w, "Resuming %s at %s\n", pr.Name, progressStatus(pr))
+		case pr.Total < 0:
+			fmt.Fprintf(p.w, "Downloading %s\n", pr.Name)
+		default:
+			fmt.Fprintf(p.w, "Downloading %s (%s)\n", pr.Name, FormatBytes(pr.Total))
+		}
+	case pr.Elapsed-p.last >= progressLogInterval:
+		p.last = pr.Elapsed
+		fmt.Fprintf(p.w, "%s: %s\n", pr.Name, progressStatus(pr))
+	}
+}
+
+// bar renders a progress line fitting the terminal width
+func (p *progressPrinter) bar(pr Progress) string {
+	status := progressStatus(pr)
+	if pr.Total <= 0 {
+		return truncate(pr.Name+"  "+status, p.width-1)
+	}
+
+	name := truncate(pr.Name, 24)
+	// Room left for the bar, between its brackets
+	size := min(p.width-len(name)-len(status)-6, 40)
+	if size < 10 {
+		return truncate(name+"  "+status, p.width-1)
+	}
+	filled := int(int64(size) * min(pr.Downloaded, pr.Total) / pr.Total)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", size-filled)
+	if filled > 0 && filled < size {
+		bar = strings.Repeat("=", filled-1) + ">" + strings.Repeat(" ", size-filled)
+	}
+	return fmt.Sprintf("%s [%s] %s", name, bar, status)
+}
+
+// progressStatus describes the downloaded size, rate and remaining time
+func progressStatus(pr Progress) string {
+	r := rate(pr)
+	if pr.Total <= 0 {
+		return fmt.Sprintf("%s  %s/s", FormatBytes(pr.Downloaded), FormatBytes(r))
+	}
+
+	status := fmt.Sprintf("%3d%%  %s/%s  %s/s", 100*pr.Downloaded/pr.Total,
+		FormatBytes(pr.Downloaded), FormatBytes(pr.Total), FormatBytes(r))
+	if r > 0 && pr.Downloaded < pr.Total {
+		eta := time.Duration(float64(pr.Total-pr.Downloaded) / float64(r) * float64(time.Second))
+		status += "  ETA " + eta.Round(time.Second).String()
+	}
+	return status
+}
+
+// rate returns the average download rate in bytes per second, not counting
+// resumed bytes
+func rate(pr Progress) int64 {
+	if pr.Elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(pr.Downloaded-pr.Resumed) / pr.Elapsed.Seconds())
+}
+
+// FormatBytes formats a size with binary units, e.g. 12.3 MiB
+func FormatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		value /= 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f TiB", value/1024)
+}
+
+// truncate shortens s to at most n bytes, marking the cut with "..."
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return s[:max(n, 0)]
+	}
+	return s[:n-3] + "..."
+}
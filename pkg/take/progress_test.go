@@ -0,0 +1,137 @@
+package take
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("take"), 64<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	var reports []Progress
+	opts := Options{
+		CacheDir: t.TempDir(),
+		Progress: func(p Progress) { reports = append(reports, p) },
+	}
+
+	if _, err := downloadFile(context.Background(), opts, ts.URL+"/file.bin", filepath.Join(t.TempDir(), "download")); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+
+	if len(reports) < 2 {
+		t.Fatalf("got %d progress reports, want at least 2", len(reports))
+	}
+	if first := reports[0]; first.Downloaded != 0 || first.Done {
+		t.Errorf("first report = %+v, want a started download", first)
+	}
+	last := reports[len(reports)-1]
+	if !last.Done || last.Downloaded != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Errorf("last report = %+v, want %d bytes done", last, len(content))
+	}
+	if last.Name != "file.bin" || last.URL != ts.URL+"/file.bin" {
+		t.Errorf("last report names %q at %q", last.Name, last.URL)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Downloaded < reports[i-1].Downloaded {
+			t.Errorf("report %d went back from %d to %d bytes", i, reports[i-1].Downloaded, reports[i].Downloaded)
+		}
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	tests := []struct {
+		name    string
+		tty     bool
+		reports []Progress
+		want    []string
+	}{
+		{
+			name: "log lines",
+			reports: []Progress{
+				{Name: "src.tar.gz", Total: 4 << 20},
+				{Name: "src.tar.gz", Downloaded: 1 << 20, Total: 4 << 20, Elapsed: time.Second},
+				{Name: "src.tar.gz", Downloaded: 2 << 20, Total: 4 << 20, Elapsed: 6 * time.Second},
+				{Name: "src.tar.gz", Downloaded: 4 << 20, Total: 4 << 20, Elapsed: 8 * time.Second, Done: true},
+			},
+			want: []string{
+				"Downloading src.tar.gz (4.0 MiB)\n",
+				"src.tar.gz:  50%  2.0 MiB/4.0 MiB  341.3 KiB/s  ETA 6s\n",
+				"src.tar.gz: 4.0 MiB in 8s (512.0 KiB/s)\n",
+			},
+		},
+		{
+			name: "log lines without size",
+			reports: []Progress{
+				{Name: "download", Total: -1},
+				{Name: "download", Downloaded: 512, Total: -1, Elapsed: time.Second, Done: true},
+			},
+			want: []string{
+				"Downloading download\n",
+				"download: 512 B in 1s (512 B/s)\n",
+			},
+		},
+		{
+			name: "failed download not summarized",
+			reports: []Progress{
+				{Name: "src.zip", Downloaded: 100, Total: 200, Elapsed: time.Second, Done: true},
+			},
+		},
+		{
+			name: "terminal bar",
+			tty:  true,
+			reports: []Progress{
+				{Name: "src.zip", Downloaded: 1 << 20, Total: 4 << 20, Elapsed: time.Second},
+				{Name: "src.zip", Downloaded: 4 << 20, Total: 4 << 20, Elapsed: 2 * time.Second, Done: true},
+			},
+			want: []string{
+				"\rsrc.zip [=========>                              ]  25%  1.0 MiB/4.0 MiB  1.0 MiB/s  ETA 3s\x1b[K",
+				"\rsrc.zip [========================================] 100%  4.0 MiB/4.0 MiB  2.0 MiB/s\x1b[K\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := &progressPrinter{w: &buf, tty: tt.tty, width: 100}
+			for _, report := range tt.reports {
+				p.print(report)
+			}
+			if got, want := buf.String(), strings.Join(tt.want, ""); got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{2 << 40, "2.0 TiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package take
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/deblasis/take/internal/signature"
+)
+
+// signatureExtensions are the suffixes of the detached signatures looked up
+// next to an archive: minisign's, then ssh-keygen's
+var signatureExtensions = []string{".minisig", ".sig"}
+
+// wantsSignature reports whether the options ask for a signature check
+func wantsSignature(opts Options) bool {
+	return opts.Signature != "" || opts.VerifySignature
+}
+
+// trustedKeys parses the TrustedKeys option, which must hold at least one
+// key when a signature is checked
+func trustedKeys(opts Options) (*signature.KeySet, error) {
+	entries := make([]string, len(opts.TrustedKeys))
+	for i, entry := range opts.TrustedKeys {
+		// Key files may be given relative to the home directory
+		if strings.HasPrefix(entry, "~") {
+			if expanded, err := expandPath(entry); err == nil {
+				entry = expanded
+			}
+		}
+		entries[i] = entry
+	}
+
+	keys, err := signature.ParseKeys(entries)
+	if err != nil {
+		return nil, err
+	}
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("%w: verifying signatures needs trusted keys", ErrInvalidTrustedKey)
+	}
+	return keys, nil
+}
+
+// verifyDownloadSignature checks file, downloaded from rawURL, against the
+// Signature option, or with VerifySignature the signature published next to
+// the URL
+func verifyDownloadSignature(ctx context.Context, opts Options, rawURL, file string) (*VerifiedSignature, error) {
+	return verifySignature(ctx, opts, file, func() ([]byte, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range signatureExtensions {
+			sig, err := fetchCachedSidecar(ctx, opts, sidecarURL(u, path.Base(u.Path)+ext))
+			if err != nil || sig != "" {
+				return []byte(sig), err
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrSignatureNotFound, rawURL)
+	})
+}
+
+// verifyFileSignature checks a local file against the Signature option, or
+// with VerifySignature the signature next to it
+func verifyFileSignature(ctx context.Context, opts Options, file string) (*VerifiedSignature, error) {
+	return verifySignature(ctx, opts, file, func() ([]byte, error) {
+		for _, ext := range signatureExtensions {
+			sig, err := os.ReadFile(file + ext)
+			if !os.IsNotExist(err) {
+				return sig, err
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrSignatureNotFound, filepath.Base(file))
+	})
+}
+
+// verifySignature checks file against the signature at the Signature
+// option, a URL or a path, or with VerifySignature the signature returned by
+// lookup. It returns nil when no signature is asked for.
+func verifySignature(ctx context.Context, opts Options, file string, lookup func() ([]byte, error)) (*VerifiedSignature, error) {
+	if !wantsSignature(opts) {
+		return nil, nil
+	}
+	keys, err := trustedKeys(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	switch {
+	case opts.Signature != "" && urlPatterns.download.MatchString(opts.Signature):
+		var content string
+		content, err = fetchCachedSidecar(ctx, opts, opts.Signature)
+		if err == nil && content == "" {
+			err = fmt.Errorf("%w: %s", ErrSignatureNotFound, opts.Signature)
+		}
+		sig = []byte(content)
+	case opts.Signature != "":
+		var sigPath string
+		if sigPath, err = expandPath(opts.Signature); err == nil {
+			sig, err = os.ReadFile(sigPath)
+		}
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%w: %s", ErrSignatureNotFound, opts.Signature)
+		}
+	default:
+		sig, err = lookup()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := keys.Verify(file, sig)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
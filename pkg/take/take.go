@@ -1,37 +1,154 @@
 package take
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
-	"archive/zip"
 
+	"github.com/deblasis/take/internal/archive"
 	"github.com/deblasis/take/internal/git"
+	"github.com/deblasis/take/internal/retry"
+	"github.com/deblasis/take/internal/signature"
 )
 
 var (
-	ErrInvalidPath      = errors.New("invalid path specified")
-	ErrPermissionDenied = errors.New("permission denied")
-	ErrGitCloneFailed   = errors.New("git clone failed")
-	ErrInvalidURL       = errors.New("invalid URL format")
-	ErrDownloadFailed   = errors.New("failed to download file")
-	ErrExtractionFailed = errors.New("failed to extract archive")
+	ErrInvalidPath        = errors.New("invalid path specified")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrGitCloneFailed     = errors.New("git clone failed")
+	ErrInvalidURL         = errors.New("invalid URL format")
+	ErrDownloadFailed     = errors.New("failed to download file")
+	ErrExtractionFailed   = errors.New("failed to extract archive")
+	ErrUnsafeArchiveEntry = archive.ErrUnsafeEntry
+	ErrArchiveTooLarge    = archive.ErrLimitExceeded
+	ErrTargetExists       = errors.New("target already exists")
+	ErrUnsupportedArchive = archive.ErrUnsupportedFormat
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrChecksumNotFound   = errors.New("no published checksum found")
+	ErrInvalidSignature   = signature.ErrInvalidSignature
+	ErrUntrustedKey       = signature.ErrUntrustedKey
+	ErrInvalidTrustedKey  = signature.ErrInvalidKey
+	ErrSignatureNotFound  = errors.New("no published signature found")
+	ErrOffline            = errors.New("not available offline")
 )
 
+// Forge maps a shorthand prefix such as "gh" to a git host
+type Forge = git.Forge
+
+// RetryPolicy describes how downloads and clones failing on network
+// trouble are retried
+type RetryPolicy = retry.Policy
+
+// VerifiedSignature describes the valid signature of an archive: its
+// format, "minisign" or "ssh", and the trusted key that made it
+type VerifiedSignature = signature.Result
+
 // Options represents configuration options for the take command
 type Options struct {
 	// Path is the target directory or URL
 	Path string
 	// GitCloneDepth for shallow clones, 0 means full clone
 	GitCloneDepth int
-	// Force will overwrite existing directory
+	// Force replaces an existing clone or extraction target. Without it
+	// Take fails with ErrTargetExists rather than touching existing files
 	Force bool
+	// Forges add shorthand prefixes to, or override, the built-in ones
+	// (gh, gl, cb, bb and sr)
+	Forges []Forge
+	// DefaultForge is the prefix bare owner/repo paths expand with. Bare
+	// paths are treated as directories when it is empty.
+	DefaultForge string
+	// GitProtocol is the protocol shorthands expand to, "https" (default)
+	// or "ssh"
+	GitProtocol string
+	// TargetDir is the directory a repository is cloned or an archive is
+	// extracted into, instead of one named after the repository or the
+	// archive's root directory. It takes precedence over CloneRoot.
+	TargetDir string
+	// CloneRoot, when set, places clones at <root>/<host>/<owner>/<repo>
+	// instead of in the current directory
+	CloneRoot string
+	// Ref is the branch, tag or commit to check out. It can also be given
+	// as a url#ref suffix and overrides the branch of a browser URL.
+	Ref string
+	// CloneFilter makes a partial clone that fetches objects on demand,
+	// e.g. "blob:none" or "tree:0"
+	CloneFilter string
+	// Sparse limits the checkout to these repository directories. With a
+	// single directory, FinalPath points inside it.
+	Sparse []string
+	// Submodules initializes git submodules recursively, shallow when
+	// GitCloneDepth is set
+	Submodules bool
+	// Template copies the repository without its history, from the forge's
+	// archive when one is available and a shallow clone otherwise
+	Template bool
+	// TemplateInit starts a new repository in a template copy, with the
+	// copied files as its first commit
+	TemplateInit bool
+	// Pull fetches and fast-forwards an existing clone that is reused
+	// instead of cloning again
+	Pull bool
+	// ArchiveType forces the format of a downloaded archive, e.g. "tar.gz",
+	// "tar.zst", "zip" or "gz" for a single file, instead of detecting it.
+	// URLs are then always downloaded as archives.
+	ArchiveType string
+	// MaxExtractSize caps the total uncompressed size of an archive in
+	// bytes. Zero uses the default limit, a negative value disables it
+	MaxExtractSize int64
+	// MaxExtractEntries caps the number of entries in an archive. Zero uses
+	// the default limit, a negative value disables it
+	MaxExtractEntries int
+	// MaxCompressionRatio caps the ratio of uncompressed to compressed
+	// size. Zero uses the default limit, a negative value disables it
+	MaxCompressionRatio float64
+	// Checksum is the expected digest of an archive, as "sha256:<hex>" or
+	// "sha512:<hex>". A download that doesn't match fails with
+	// ErrChecksumMismatch before it is extracted.
+	Checksum string
+	// VerifyChecksum looks up the checksum of a downloaded archive, when
+	// Checksum is not set, in the <file>.sha256, <file>.sha512, SHA256SUMS
+	// or SHA512SUMS files next to it. Without one, Take fails with
+	// ErrChecksumNotFound.
+	VerifyChecksum bool
+	// TrustedKeys are the minisign or SSH public keys archive signatures
+	// are trusted from, each given inline or as a file of keys such as a
+	// minisign .pub or an allowed_signers file
+	TrustedKeys []string
+	// Signature is the URL or path of a detached minisign or ssh-keygen
+	// signature of the archive, which must be made by one of TrustedKeys.
+	// An archive without a valid signature fails with ErrInvalidSignature
+	// or ErrUntrustedKey before it is extracted.
+	Signature string
+	// VerifySignature looks up the signature of an archive, when Signature
+	// is not set, as <file>.minisig or <file>.sig next to it. Without one,
+	// Take fails with ErrSignatureNotFound.
+	VerifySignature bool
+	// Retry is how downloads and clones that fail on network trouble or
+	// with a retryable HTTP status are retried. Zero fields use the
+	// defaults of three attempts with exponential backoff from 1s.
+	Retry RetryPolicy
+	// HTTPClient makes the download requests, e.g. with a proxy, custom
+	// certificate authorities or client certificates. Nil uses
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// CacheDir is where downloads are cached, and interrupted ones kept to
+	// be resumed, take in the user's cache directory by default
+	CacheDir string
+	// Offline takes downloads from the cache only and never clones, failing
+	// with ErrOffline when that isn't enough
+	Offline bool
+	// Progress, when set, is called as downloads advance: when they start,
+	// at most every 100ms while data arrives, and once they are done
+	Progress func(Progress)
 }
 
 // Result represents the outcome of a take operation
@@ -42,45 +159,141 @@ type Result struct {
 	WasCreated bool
 	// WasCloned indicates if a git repository was cloned
 	WasCloned bool
+	// WasReused indicates if an existing clone of the repository was used
+	WasReused bool
 	// WasDownloaded indicates if a file was downloaded
 	WasDownloaded bool
+	// FromCache indicates if the download was taken from the download cache
+	FromCache bool
+	// WasExtracted indicates if a local archive file was extracted
+	WasExtracted bool
+	// Signature describes the verified signature of the archive, nil when
+	// no signature was checked
+	Signature *VerifiedSignature
 	// Error if any occurred
 	Error error
 }
 
 // urlPatterns defines regex patterns for different URL types
 var urlPatterns = struct {
-	git     *regexp.Regexp
-	tarball *regexp.Regexp
-	zip     *regexp.Regexp
+	git      *regexp.Regexp
+	tarball  *regexp.Regexp
+	zip      *regexp.Regexp
+	download *regexp.Regexp
 }{
-	git:     regexp.MustCompile(`^([A-Za-z0-9]+@|https?|git|ssh|ftps?|rsync).*\.git/?$`),
-	tarball: regexp.MustCompile(`^(https?|ftp).*\.(tar\.(gz|bz2|xz)|tgz)$`),
-	zip:     regexp.MustCompile(`^(https?|ftp).*\.(zip)$`),
+	git:      regexp.MustCompile(`^([A-Za-z0-9]+@|https?|git|ssh|ftps?|rsync).*\.git/?$`),
+	tarball:  regexp.MustCompile(`^(https?|ftp).*\.(tar\.(gz|bz2|xz|zst|lz4)|tgz|tbz2|txz|tzst|gz|xz)$`),
+	zip:      regexp.MustCompile(`^(https?|ftp).*\.(zip)$`),
+	download: regexp.MustCompile(`^https?://`),
 }
 
 // Take executes the take command with the given options
 func Take(opts Options) Result {
+	return TakeContext(context.Background(), opts)
+}
+
+// TakeContext executes the take command with the given options, giving up
+// once ctx is done: downloads and git commands are interrupted, temporary
+// and partially extracted files are removed, and the result's error wraps
+// the context's error. Only an interrupted download that can be resumed is
+// kept, in the cache directory.
+func TakeContext(ctx context.Context, opts Options) Result {
 	// Validate input
 	if opts.Path == "" {
 		return Result{Error: ErrInvalidPath}
 	}
+	if err := ctx.Err(); err != nil {
+		return Result{Error: err}
+	}
+	if opts.Checksum != "" {
+		if _, err := parseChecksum(opts.Checksum); err != nil {
+			return Result{Error: err}
+		}
+	}
+	if wantsSignature(opts) {
+		if _, err := trustedKeys(opts); err != nil {
+			return Result{Error: err}
+		}
+	}
+
+	// Split a url#ref suffix and expand forge shorthands such as
+	// gh:owner/repo, unless the path names something that exists locally
+	localPath, err := expandPath(opts.Path)
+	if err != nil {
+		return Result{Error: err}
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		path, ref := git.SplitRef(opts.Path)
+		if ref != "" {
+			if opts.Ref != "" && opts.Ref != ref {
+				return Result{Error: fmt.Errorf("%w: conflicting refs %q and %q", ErrInvalidURL, ref, opts.Ref)}
+			}
+			opts.Ref = ref
+		}
+		if expanded, ok := shorthands(opts).Expand(path); ok {
+			path = expanded
+		}
+		opts.Path = path
+	}
+
+	// Handle local archive files
+	if err == nil && info.Mode().IsRegular() {
+		return handleArchiveFile(ctx, opts, localPath)
+	}
 
 	// Handle URLs and git repos
 	if strings.Contains(opts.Path, "://") || strings.Contains(opts.Path, "@") || git.IsGitRepo(opts.Path) {
 		switch {
+		case opts.ArchiveType != "" && urlPatterns.download.MatchString(opts.Path):
+			return handleArchiveURL(ctx, opts)
 		case git.IsGitRepo(opts.Path) || urlPatterns.git.MatchString(opts.Path):
-			return handleGitURL(opts)
-		case urlPatterns.tarball.MatchString(opts.Path):
-			return handleTarballURL(opts)
-		case urlPatterns.zip.MatchString(opts.Path):
-			return handleZipURL(opts)
-		default:
-			return Result{Error: ErrInvalidURL}
+			return handleGitURL(ctx, opts, git.Location{URL: opts.Path})
+		case urlPatterns.tarball.MatchString(opts.Path), urlPatterns.zip.MatchString(opts.Path):
+			return handleArchiveURL(ctx, opts)
 		}
+
+		// Browser URLs of known forges, and repositories on other hosts
+		// without a .git suffix
+		if loc, ok := git.ParseWebURL(ctx, opts.Path, forges(opts), opts.Offline); ok {
+			return handleGitURL(ctx, opts, loc)
+		}
+		// Repositories of known forges need no probe, which could prompt
+		// for an SSH passphrase
+		if git.IsValidURL(opts.Path) {
+			return handleGitURL(ctx, opts, git.Location{URL: opts.Path})
+		}
+		// Offline, nothing may ask the server what it serves
+		if !opts.Offline && git.IsRemoteRepo(ctx, opts.Path) {
+			return handleGitURL(ctx, opts, git.Location{URL: opts.Path, Known: true})
+		}
+
+		// Anything else may still serve an archive, such as download links
+		// with query strings or redirects to release assets
+		if urlPatterns.download.MatchString(opts.Path) {
+			result := handleArchiveURL(ctx, opts)
+			if (errors.Is(result.Error, ErrDownloadFailed) || errors.Is(result.Error, ErrUnsupportedArchive)) && ctx.Err() == nil {
+				result.Error = fmt.Errorf("%w: %w", ErrInvalidURL, result.Error)
+			}
+			return result
+		}
+		if opts.Offline {
+			return Result{Error: fmt.Errorf("%w: %s may be a repository to clone", ErrOffline, opts.Path)}
+		}
+		return Result{Error: ErrInvalidURL}
 	}
 
 	// Handle local directory
+	if opts.TargetDir != "" {
+		return Result{Error: fmt.Errorf("%w: a target directory needs a repository or archive source", ErrInvalidPath)}
+	}
+	if opts.Checksum != "" || opts.VerifyChecksum {
+		return Result{Error: fmt.Errorf("%w: checksums only apply to archives", ErrInvalidPath)}
+	}
+	if wantsSignature(opts) {
+		return Result{Error: fmt.Errorf("%w: signatures only apply to archives", ErrInvalidPath)}
+	}
+
 	expandedPath, err := expandPath(opts.Path)
 	if err != nil {
 		return Result{Error: err}
@@ -135,114 +348,236 @@ func handleDirectory(opts Options) Result {
 	}
 }
 
-// handleGitURL handles git repository cloning
-func handleGitURL(opts Options) Result {
-	targetDir := git.GetRepoName(opts.Path)
-	if targetDir == "" {
-		targetDir = filepath.Base(opts.Path)
+// handleGitURL handles git repository cloning. When loc names a
+// subdirectory, the result points inside the clone.
+func handleGitURL(ctx context.Context, opts Options, loc git.Location) Result {
+	if opts.Checksum != "" || opts.VerifyChecksum {
+		return Result{Error: fmt.Errorf("%w: checksums only apply to archives", ErrInvalidURL)}
+	}
+	if wantsSignature(opts) {
+		return Result{Error: fmt.Errorf("%w: signatures only apply to archives", ErrInvalidURL)}
+	}
+	if opts.Ref != "" {
+		loc.Ref = opts.Ref
+	}
+	if opts.Template {
+		return handleTemplate(ctx, opts, loc)
 	}
 
-	err := git.Clone(git.CloneOptions{
-		URL:       opts.Path,
-		TargetDir: targetDir,
-		Depth:     opts.GitCloneDepth,
+	targetDir, err := gitTargetDir(loc.URL, opts)
+	if err != nil {
+		return Result{Error: err}
+	}
+
+	sparse, err := sparsePaths(opts.Sparse, loc.Subdir)
+	if err != nil {
+		return Result{Error: err}
+	}
+	if loc.Subdir == "" && len(sparse) == 1 {
+		loc.Subdir = sparse[0]
+	}
+	opts.Sparse = sparse
+
+	// A local repository taken into its own directory is returned as it
+	// is: cloning it over itself, even with Force, would lose its changes
+	if source, ok := localSource(loc.URL); ok {
+		same, nested, err := overlap(source, targetDir)
+		if err != nil {
+			return Result{Error: err}
+		}
+		if nested {
+			return Result{Error: fmt.Errorf("%w: %s and the repository %s contain one another", ErrInvalidPath, targetDir, source)}
+		}
+		if same {
+			absPath, err := repoSubdir(targetDir, loc.Subdir)
+			if err != nil {
+				return Result{Error: err}
+			}
+			return Result{FinalPath: absPath, WasReused: true}
+		}
+	}
+
+	// Reuse an existing clone of the same repository
+	if !opts.Force && isCloneOf(targetDir, loc.URL) {
+		return reuseClone(ctx, targetDir, loc, opts)
+	}
+
+	if opts.Offline {
+		return Result{Error: fmt.Errorf("%w: cloning %s needs the network", ErrOffline, loc.URL)}
+	}
+	if err := checkTarget(targetDir, opts.Force); err != nil {
+		return Result{Error: err}
+	}
+
+	// Clone next to the target so a failed clone never touches it
+	stagedDir, err := stagingDir(targetDir)
+	if err != nil {
+		return Result{Error: err}
+	}
+	defer os.RemoveAll(stagedDir)
+
+	err = git.Clone(ctx, git.CloneOptions{
+		URL:        loc.URL,
+		TargetDir:  stagedDir,
+		Depth:      opts.GitCloneDepth,
+		Ref:        loc.Ref,
+		Filter:     opts.CloneFilter,
+		Sparse:     opts.Sparse,
+		Submodules: opts.Submodules,
+		Retry:      opts.Retry,
+		Known:      loc.Known,
 	})
 
 	if err != nil {
 		return Result{Error: fmt.Errorf("failed to clone repository: %w", err)}
 	}
 
-	absPath, err := filepath.Abs(targetDir)
+	if err := installDir(stagedDir, targetDir, opts.Force); err != nil {
+		return Result{Error: err}
+	}
+
+	absPath, err := repoSubdir(targetDir, loc.Subdir)
 	if err != nil {
 		return Result{Error: err}
 	}
 
 	return Result{
-		FinalPath:  absPath,
-		WasCloned:  true,
+		FinalPath: absPath,
+		WasCloned: true,
 	}
 }
 
-// handleTarballURL downloads and extracts a tarball
-func handleTarballURL(opts Options) Result {
-	// Create temporary directory
-	tmpDir, err := os.MkdirTemp("", "take-*")
-	if err != nil {
-		return Result{Error: err}
+// sparsePaths cleans the sparse-checkout paths and adds the subdirectory
+// named by a browser URL, so it is checked out too
+func sparsePaths(paths []string, subdir string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// Create temporary file with proper extension
-	ext := filepath.Ext(opts.Path)
-	if ext == "" {
-		ext = ".tar.gz" // default extension
+	var cleaned []string
+	for _, p := range append(slices.Clone(paths), subdir) {
+		if p == "" {
+			continue
+		}
+		p = path.Clean(strings.Trim(filepath.ToSlash(p), "/"))
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") || filepath.IsAbs(p) {
+			return nil, fmt.Errorf("%w: sparse path %s is outside the repository", ErrInvalidPath, p)
+		}
+		if !slices.Contains(cleaned, p) {
+			cleaned = append(cleaned, p)
+		}
 	}
-	tmpFile, err := os.CreateTemp(tmpDir, "archive-*"+ext)
-	if err != nil {
-		return Result{Error: err}
+	return cleaned, nil
+}
+
+// repoSubdir returns the absolute path of subdir inside the repository
+func repoSubdir(repoDir, subdir string) (string, error) {
+	path := filepath.Join(repoDir, filepath.FromSlash(subdir))
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: %s not found in repository", ErrInvalidPath, subdir)
 	}
-	defer os.Remove(tmpFile.Name())
+	return filepath.Abs(path)
+}
 
-	// Download file
-	resp, err := http.Get(opts.Path)
-	if err != nil {
-		return Result{Error: ErrDownloadFailed}
+// forges returns the configured forges followed by the built-in ones
+func forges(opts Options) []git.Forge {
+	forges := append([]git.Forge{}, opts.Forges...)
+	return append(forges, git.DefaultForges...)
+}
+
+// shorthands builds the forge shorthand table from the options
+func shorthands(opts Options) git.Shorthands {
+	return git.Shorthands{
+		Forges:   forges(opts),
+		Default:  opts.DefaultForge,
+		Protocol: git.Protocol(opts.GitProtocol),
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return Result{Error: ErrDownloadFailed}
+// gitTargetDir returns the directory a repository is cloned into: the
+// requested target, below the clone root when one is configured, otherwise
+// in the current directory
+func gitTargetDir(repoURL string, opts Options) (string, error) {
+	if opts.TargetDir != "" {
+		return expandPath(opts.TargetDir)
 	}
 
-	// Copy to temp file
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return Result{Error: ErrDownloadFailed}
+	if opts.CloneRoot != "" {
+		if repoPath, ok := git.RepoPath(repoURL); ok {
+			root, err := expandPath(opts.CloneRoot)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(root, filepath.FromSlash(repoPath)), nil
+		}
 	}
-	tmpFile.Close()
 
-	// Extract archive
-	cmd := exec.Command("tar", "xf", tmpFile.Name(), "-C", tmpDir)
-	if err := cmd.Run(); err != nil {
-		return Result{Error: fmt.Errorf("tar extraction failed: %v", err)}
+	targetDir := git.GetRepoName(repoURL)
+	if targetDir == "" {
+		targetDir = filepath.Base(repoURL)
 	}
+	return targetDir, nil
+}
 
-	// Find the extracted directory
-	entries, err := os.ReadDir(tmpDir)
-	if err != nil {
-		return Result{Error: err}
+// isCloneOf reports whether dir is a git repository whose origin is url
+func isCloneOf(dir, url string) bool {
+	if !git.IsGitRepo(dir) {
+		return false
 	}
+	origin, err := git.RemoteURL(dir, "origin")
+	return err == nil && git.SameRepo(origin, url)
+}
 
-	var extractedDir string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			extractedDir = entry.Name()
-			break
+// reuseClone returns an existing clone, fast-forwarding it if requested
+func reuseClone(ctx context.Context, dir string, loc git.Location, opts Options) Result {
+	if loc.Ref != "" {
+		err := git.Checkout(ctx, dir, loc.Ref, opts.Offline)
+		if errors.Is(err, git.ErrRefNotLocal) {
+			return Result{Error: fmt.Errorf("%w: fetching %s needs the network", ErrOffline, loc.Ref)}
+		}
+		if err != nil {
+			return Result{Error: fmt.Errorf("failed to check out %s: %w", loc.Ref, err)}
 		}
 	}
 
-	if extractedDir == "" {
-		return Result{Error: fmt.Errorf("no directory found in archive")}
+	// Tags and commits leave a detached HEAD with nothing to fast-forward
+	if opts.Pull && opts.Offline {
+		return Result{Error: fmt.Errorf("%w: pulling %s needs the network", ErrOffline, loc.URL)}
+	}
+	if opts.Pull && git.OnBranch(ctx, dir) {
+		if err := git.Pull(ctx, dir); err != nil {
+			return Result{Error: fmt.Errorf("failed to update repository: %w", err)}
+		}
 	}
 
-	// Move the extracted directory to the current directory
-	finalPath := filepath.Join(".", extractedDir)
-	if err := os.Rename(filepath.Join(tmpDir, extractedDir), finalPath); err != nil {
-		return Result{Error: err}
+	// Sparse paths are added, an existing checkout is never narrowed
+	if len(opts.Sparse) > 0 {
+		if err := git.SparseCheckout(ctx, dir, opts.Sparse); err != nil {
+			return Result{Error: fmt.Errorf("failed to update sparse checkout: %w", err)}
+		}
 	}
 
-	absPath, err := filepath.Abs(finalPath)
+	if opts.Submodules {
+		if err := git.UpdateSubmodules(ctx, dir, opts.GitCloneDepth > 0); err != nil {
+			return Result{Error: fmt.Errorf("failed to update submodules: %w", err)}
+		}
+	}
+
+	absPath, err := repoSubdir(dir, loc.Subdir)
 	if err != nil {
 		return Result{Error: err}
 	}
 
 	return Result{
-		FinalPath:     absPath,
-		WasDownloaded: true,
+		FinalPath: absPath,
+		WasReused: true,
 	}
 }
 
-// handleZipURL downloads and extracts a zip file
-func handleZipURL(opts Options) Result {
+// handleArchiveURL downloads and extracts an archive or compressed file. The
+// format is detected once the archive is downloaded, so URLs without an
+// archive extension work too.
+func handleArchiveURL(ctx context.Context, opts Options) Result {
 	// Create temporary directory
 	tmpDir, err := os.MkdirTemp("", "take-*")
 	if err != nil {
@@ -250,114 +585,187 @@ func handleZipURL(opts Options) Result {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Create temporary file
-	tmpFile, err := os.CreateTemp(tmpDir, "archive-*.zip")
+	// Download file
+	downloadPath := filepath.Join(tmpDir, "download")
+	header, fromCache, err := fetchDownload(ctx, opts, opts.Path, downloadPath, nil)
 	if err != nil {
-		return Result{Error: err}
+		return Result{Error: downloadError(err)}
 	}
-	defer os.Remove(tmpFile.Name())
 
-	// Download file
-	resp, err := http.Get(opts.Path)
+	// Nothing reads the download before it is verified
+	name := downloadName(opts.Path, header)
+	if err := verifyDownload(ctx, opts, opts.Path, name, downloadPath); err != nil {
+		return Result{Error: err}
+	}
+	sig, err := verifyDownloadSignature(ctx, opts, opts.Path, downloadPath)
 	if err != nil {
-		return Result{Error: ErrDownloadFailed}
+		return Result{Error: err}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return Result{Error: ErrDownloadFailed}
+	format, err := downloadFormat(opts, downloadPath, name, header.Get("Content-Type"))
+	if err != nil {
+		return Result{Error: err}
 	}
 
-	// Copy to temp file
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return Result{Error: ErrDownloadFailed}
+	// Single compressed files are extracted under the name of the download
+	archivePath := filepath.Join(tmpDir, name)
+	if err := os.Rename(downloadPath, archivePath); err != nil {
+		return Result{Error: err}
 	}
-	tmpFile.Close()
 
-	// Open the zip file for reading
-	zipReader, err := zip.OpenReader(tmpFile.Name())
-	if err != nil {
-		return Result{Error: fmt.Errorf("failed to open zip: %v", err)}
+	result := extractTo(opts, name, func(dst string) error {
+		if err := archive.Extract(ctx, archivePath, format, dst, extractLimits(opts)); err != nil {
+			return fmt.Errorf("%w: %w", ErrExtractionFailed, err)
+		}
+		return nil
+	})
+	result.WasDownloaded = result.Error == nil
+	if result.Error == nil {
+		result.FromCache = fromCache
+		result.Signature = sig
 	}
-	defer zipReader.Close()
+	return result
+}
 
-	// Find the root directory in the zip
-	var rootDir string
-	for _, file := range zipReader.File {
-		name := filepath.ToSlash(file.Name)
-		parts := strings.Split(name, "/")
+// downloadError reports a failed download as ErrDownloadFailed, keeping its
+// cause so that cancellation can be told apart
+func downloadError(err error) error {
+	if errors.Is(err, ErrDownloadFailed) || errors.Is(err, ErrOffline) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+}
 
-		// Skip files/directories starting with "." or "_"
-		if strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[0], "_") {
-			continue
+// downloadFormat picks the format of a downloaded archive: the ArchiveType
+// option, then the file's magic bytes, then the file name, then the
+// Content-Type the server sent
+func downloadFormat(opts Options, path, name, contentType string) (archive.Format, error) {
+	if opts.ArchiveType != "" {
+		if format := archive.ParseFormat(opts.ArchiveType); format != archive.FormatUnknown {
+			return format, nil
 		}
+		return archive.FormatUnknown, fmt.Errorf("%w: %s", ErrUnsupportedArchive, opts.ArchiveType)
+	}
 
-		// Get the top-level directory
-		if rootDir == "" {
-			rootDir = parts[0]
-		} else if parts[0] != rootDir {
-			// If we find a different top-level directory, use the common parent
-			if rootDir != filepath.Dir(name) {
-				rootDir = ""
-				break
-			}
+	format, err := archive.DetectFile(path)
+	if err != nil {
+		return archive.FormatUnknown, err
+	}
+	if format == archive.FormatUnknown {
+		format = archive.FormatFromName(name)
+	}
+	if format == archive.FormatUnknown {
+		format = archive.FormatFromContentType(contentType)
+	}
+	if format == archive.FormatUnknown {
+		return archive.FormatUnknown, fmt.Errorf("%w: cannot detect the format of %s", ErrUnsupportedArchive, name)
+	}
+	return format, nil
+}
+
+// downloadName returns the file name of a download: the one suggested by
+// the server's Content-Disposition header, else the last segment of the URL
+// path
+func downloadName(rawURL string, header http.Header) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		// Only the base name is used, the server doesn't pick directories
+		name := filepath.Base(filepath.FromSlash(strings.ReplaceAll(params["filename"], `\`, "/")))
+		if name != "." && name != ".." && name != string(filepath.Separator) {
+			return name
 		}
 	}
 
-	if rootDir == "" {
-		// If no root dir found, use the base name of the zip without extension
-		rootDir = strings.TrimSuffix(filepath.Base(opts.Path), ".zip")
-		// Create the root directory
-		if err := os.MkdirAll(filepath.Join(tmpDir, rootDir), 0755); err != nil {
-			return Result{Error: fmt.Errorf("failed to create root directory: %v", err)}
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return name
 		}
 	}
+	return "archive"
+}
+
+// handleArchiveFile extracts a local archive file, identified by its content
+// rather than its name
+func handleArchiveFile(ctx context.Context, opts Options, path string) Result {
+	if err := verifyFile(opts, path); err != nil {
+		return Result{Error: err}
+	}
+	sig, err := verifyFileSignature(ctx, opts, path)
+	if err != nil {
+		return Result{Error: err}
+	}
 
-	// Extract files
-	for _, file := range zipReader.File {
-		// Calculate extraction path
-		path := filepath.Join(tmpDir, file.Name)
+	format, err := archive.DetectFile(path)
+	if err != nil {
+		return Result{Error: err}
+	}
+	if format == archive.FormatUnknown {
+		return Result{Error: fmt.Errorf("%w: %s is not a directory or a supported archive", ErrInvalidPath, path)}
+	}
 
-		if file.FileInfo().IsDir() {
-			if err := os.MkdirAll(path, file.Mode()); err != nil {
-				return Result{Error: fmt.Errorf("failed to create directory: %v", err)}
-			}
-			continue
+	result := extractTo(opts, filepath.Base(path), func(dst string) error {
+		if err := archive.Extract(ctx, path, format, dst, extractLimits(opts)); err != nil {
+			return fmt.Errorf("%w: %w", ErrExtractionFailed, err)
 		}
+		return nil
+	})
+	result.WasExtracted = result.Error == nil
+	if result.Error == nil {
+		result.Signature = sig
+	}
+	return result
+}
 
-		// Create parent directories if needed
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return Result{Error: fmt.Errorf("failed to create parent directory: %v", err)}
+// extractTo runs extract on a staging directory and moves the archive's root
+// directory into place. Archives without a single root directory are placed
+// in a directory named after the archive file name.
+func extractTo(opts Options, name string, extract func(dst string) error) Result {
+	// Without a target directory, the destination is named after the
+	// archive's root directory, which is only known after extraction
+	var targetDir string
+	if opts.TargetDir != "" {
+		var err error
+		if targetDir, err = expandPath(opts.TargetDir); err != nil {
+			return Result{Error: err}
 		}
-
-		// Create the file
-		dstFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return Result{Error: fmt.Errorf("failed to create file: %v", err)}
+		if err := checkTarget(targetDir, opts.Force); err != nil {
+			return Result{Error: err}
 		}
+	}
 
-		// Open the file in the zip
-		srcFile, err := file.Open()
-		if err != nil {
-			dstFile.Close()
-			return Result{Error: fmt.Errorf("failed to open file in zip: %v", err)}
-		}
+	// Extract next to the destination so it can be moved into place
+	// atomically
+	stageFor := targetDir
+	if stageFor == "" {
+		stageFor = filepath.Join(".", archive.TrimExt(name))
+	}
+	contentsDir, err := stagingDir(stageFor)
+	if err != nil {
+		return Result{Error: err}
+	}
+	defer os.RemoveAll(contentsDir)
 
-		// Copy the contents
-		_, err = io.Copy(dstFile, srcFile)
-		srcFile.Close()
-		dstFile.Close()
-		if err != nil {
-			return Result{Error: fmt.Errorf("failed to extract file: %v", err)}
-		}
+	if err := extract(contentsDir); err != nil {
+		return Result{Error: err}
+	}
+
+	// Use the archive's root directory, or wrap loose entries in a
+	// directory named after the archive
+	extractedDir := contentsDir
+	rootDir, ok := archive.FindRoot(contentsDir)
+	if ok {
+		extractedDir = filepath.Join(contentsDir, rootDir)
+	} else {
+		rootDir = archive.TrimExt(name)
 	}
 
-	// Move the extracted directory to the current directory
-	finalPath := filepath.Join(".", rootDir)
-	// Remove target directory if it exists
-	os.RemoveAll(finalPath)
-	if err := os.Rename(filepath.Join(tmpDir, rootDir), finalPath); err != nil {
-		return Result{Error: fmt.Errorf("failed to move directory: %v", err)}
+	// Move the extracted directory to the current directory, or to the
+	// requested target
+	finalPath := targetDir
+	if finalPath == "" {
+		finalPath = filepath.Join(".", rootDir)
+	}
+	if err := installDir(extractedDir, finalPath, opts.Force); err != nil {
+		return Result{Error: err}
 	}
 
 	absPath, err := filepath.Abs(finalPath)
@@ -365,26 +773,64 @@ func handleZipURL(opts Options) Result {
 		return Result{Error: err}
 	}
 
-	return Result{
-		FinalPath:     absPath,
-		WasDownloaded: true,
-	}
+	return Result{FinalPath: absPath}
 }
 
-// downloadFile downloads a file from a URL to a local file
-func downloadFile(url string, file *os.File) error {
-	resp, err := http.Get(url)
+// fetchArchive downloads the archive at rawURL and extracts it into dst
+func fetchArchive(ctx context.Context, opts Options, rawURL string, format archive.Format, dst string) error {
+	// Create temporary directory
+	tmpDir, err := os.MkdirTemp("", "take-*")
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer os.RemoveAll(tmpDir)
+
+	// Download file. Servers may answer with a page, such as a sign-in
+	// form, instead of the archive.
+	archivePath := filepath.Join(tmpDir, "archive."+format.String())
+	isArchive := func(path string) error {
+		if detected, err := archive.DetectFile(path); err != nil || detected != format {
+			return fmt.Errorf("%w: %s is not a %s archive", ErrDownloadFailed, rawURL, format)
+		}
+		return nil
+	}
+	if _, _, err := fetchDownload(ctx, opts, rawURL, archivePath, isArchive); err != nil {
+		return downloadError(err)
+	}
+
+	// Extract archive
+	if err := archive.Extract(ctx, archivePath, format, dst, extractLimits(opts)); err != nil {
+		return fmt.Errorf("%w: %w", ErrExtractionFailed, err)
+	}
+	return nil
+}
+
+// extractLimits builds the archive bomb limits from the options
+func extractLimits(opts Options) archive.Limits {
+	limits := archive.DefaultLimits
+
+	switch {
+	case opts.MaxExtractSize > 0:
+		limits.MaxBytes = opts.MaxExtractSize
+	case opts.MaxExtractSize < 0:
+		limits.MaxBytes = 0
+	}
+
+	switch {
+	case opts.MaxExtractEntries > 0:
+		limits.MaxEntries = opts.MaxExtractEntries
+	case opts.MaxExtractEntries < 0:
+		limits.MaxEntries = 0
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return ErrDownloadFailed
+	switch {
+	case opts.MaxCompressionRatio > 0:
+		limits.MaxRatio = opts.MaxCompressionRatio
+	case opts.MaxCompressionRatio < 0:
+		limits.MaxRatio = 0
 	}
 
-	_, err = io.Copy(file, resp.Body)
-	return err
+	return limits
 }
 
 // expandPath expands the given path, handling home directory (~) expansion
@@ -1,15 +1,30 @@
 package take
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"path/filepath"
-	"testing"
 	"os/exec"
-	"archive/zip"
-	"io"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"aead.dev/minisign"
+
+	"github.com/deblasis/take/internal/git"
 )
 
 type testCase struct {
@@ -48,6 +63,34 @@ func createMockTarball(t *testing.T) string {
 	return tarPath
 }
 
+// createLinkedTarball creates a tarball whose root directory holds a
+// symlink next to its target
+func createLinkedTarball(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "take-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	projDir := filepath.Join(dir, "proj")
+	if err := os.MkdirAll(projDir, 0755); err != nil {
+		t.Fatalf("Failed to create project directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(projDir, "README"), []byte("readme"), 0644); err != nil {
+		t.Fatalf("Failed to create README: %v", err)
+	}
+	if err := os.Symlink("README", filepath.Join(projDir, "README.link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tarPath := filepath.Join(dir, "proj.tar.gz")
+	cmd := exec.Command("tar", "czf", tarPath, "-C", dir, "proj")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create tarball: %v\nOutput: %s", err, out)
+	}
+
+	return tarPath
+}
+
 func createMockZip(t *testing.T) string {
 	dir, err := os.MkdirTemp("", "take-test-*")
 	if err != nil {
@@ -121,6 +164,35 @@ func createMockZip(t *testing.T) string {
 	return zipPath
 }
 
+// createMaliciousZip creates a zip whose entry escapes the extraction root
+func createMaliciousZip(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "take-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	zipPath := filepath.Join(dir, "evil.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to create zip file: %v", err)
+	}
+	defer zipFile.Close()
+
+	zipWriter := zip.NewWriter(zipFile)
+	writer, err := zipWriter.Create("../../take-zip-slip.txt")
+	if err != nil {
+		t.Fatalf("Failed to create zip entry: %v", err)
+	}
+	if _, err := writer.Write([]byte("evil")); err != nil {
+		t.Fatalf("Failed to write zip entry: %v", err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+
+	return zipPath
+}
+
 // Create a mock git repository for testing
 func createTestRepo(t *testing.T) string {
 	repoDir, err := os.MkdirTemp("", "test-repo-*")
@@ -168,24 +240,95 @@ func TestTake(t *testing.T) {
 	// Create mock archives
 	tarPath := createMockTarball(t)
 	zipPath := createMockZip(t)
+	evilZipPath := createMaliciousZip(t)
+	linkedTarPath := createLinkedTarball(t)
 	defer os.RemoveAll(filepath.Dir(tarPath))
 	defer os.RemoveAll(filepath.Dir(zipPath))
+	defer os.RemoveAll(filepath.Dir(evilZipPath))
+	defer os.RemoveAll(filepath.Dir(linkedTarPath))
+
+	// Keep partial downloads out of the user's cache
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	tarContent, err := os.ReadFile(tarPath)
+	if err != nil {
+		t.Fatalf("Failed to read tarball: %v", err)
+	}
+	tarSum := sha256.Sum256(tarContent)
+	tarSHA256 := hex.EncodeToString(tarSum[:])
+
+	// Sign the tarball, publishing the signature next to the download and
+	// the local file
+	publicKey, privateKey, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	trustedKey, err := publicKey.MarshalText()
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	otherKey, _, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	untrustedKey, err := otherKey.MarshalText()
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	signer := minisign.NewReader(bytes.NewReader(tarContent))
+	if _, err := io.Copy(io.Discard, signer); err != nil {
+		t.Fatalf("Failed to sign tarball: %v", err)
+	}
+	tarSignature := signer.Sign(privateKey)
+	if err := os.WriteFile(tarPath+".minisig", tarSignature, 0644); err != nil {
+		t.Fatalf("Failed to write signature: %v", err)
+	}
 
 	// Create test server for archive downloads
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
-		case "/test.tar.gz":
+		case "/test.tar.gz", "/user/starter/archive/HEAD.tar.gz":
 			content, err := os.ReadFile(tarPath)
 			if err != nil {
 				t.Fatalf("Failed to read tarball: %v", err)
 			}
 			w.Write(content)
+		case "/proj.tar.gz", "/user/linked/archive/HEAD.tar.gz":
+			content, err := os.ReadFile(linkedTarPath)
+			if err != nil {
+				t.Fatalf("Failed to read tarball: %v", err)
+			}
+			w.Write(content)
 		case "/test.zip":
 			content, err := os.ReadFile(zipPath)
 			if err != nil {
 				t.Fatalf("Failed to read zip: %v", err)
 			}
 			w.Write(content)
+		case "/download":
+			content, err := os.ReadFile(tarPath)
+			if err != nil {
+				t.Fatalf("Failed to read tarball: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(content)
+		case "/test.tar.gz.sha256":
+			w.Write([]byte(tarSHA256 + "  test.tar.gz\n"))
+		case "/test.tar.gz.minisig":
+			w.Write(tarSignature)
+		case "/dump.sql.gz":
+			gw := gzip.NewWriter(w)
+			gw.Write([]byte("CREATE TABLE test;\n"))
+			gw.Close()
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/evil.zip":
+			content, err := os.ReadFile(evilZipPath)
+			if err != nil {
+				t.Fatalf("Failed to read zip: %v", err)
+			}
+			w.Write(content)
 		default:
 			http.NotFound(w, r)
 		}
@@ -210,6 +353,8 @@ func TestTake(t *testing.T) {
 		t.Fatalf("Failed to change to temp dir: %v", err)
 	}
 
+	tsHost := strings.TrimPrefix(ts.URL, "http://")
+
 	// Helper function to create paths relative to temp dir
 	tmpPath := func(path string) string {
 		return filepath.Join(tmpDir, path)
@@ -219,6 +364,23 @@ func TestTake(t *testing.T) {
 	testRepo := createTestRepo(t)
 	defer os.RemoveAll(testRepo)
 
+	// Create a repository whose tag v1 lags behind its tip
+	taggedRepo := createTestRepo(t)
+	defer os.RemoveAll(taggedRepo)
+	if err := os.WriteFile(filepath.Join(taggedRepo, "test.txt"), []byte("v2"), 0644); err != nil {
+		t.Fatalf("Failed to update test file: %v", err)
+	}
+	for _, args := range [][]string{
+		{"tag", "v1"},
+		{"commit", "-am", "Second commit"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = taggedRepo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+
 	tests := []struct {
 		name        string
 		opts        Options
@@ -325,6 +487,309 @@ func TestTake(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "reuse existing clone",
+			opts: Options{
+				Path: testRepo,
+				Pull: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasReused || got.WasCloned {
+					t.Errorf("Expected existing clone to be reused, got %+v", got)
+				}
+			},
+		},
+		{
+			name: "force replaces existing git target",
+			setup: func(t *testing.T) {
+				marker := tmpPath(filepath.Join(filepath.Base(testRepo), "marker.txt"))
+				if err := os.WriteFile(marker, []byte("old"), 0644); err != nil {
+					t.Fatalf("Failed to create marker file: %v", err)
+				}
+			},
+			opts: Options{
+				Path:  testRepo,
+				Force: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "marker.txt")); !os.IsNotExist(err) {
+					t.Error("Expected existing target to be replaced")
+				}
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected cloned file in target: %v", err)
+				}
+				leftovers, _ := filepath.Glob(tmpPath(".*.take-*"))
+				if len(leftovers) != 0 {
+					t.Errorf("Expected staging and backup directories to be removed, found %v", leftovers)
+				}
+			},
+		},
+		{
+			name: "force keeps a local repository taken into itself",
+			setup: func(t *testing.T) {
+				repo := createTestRepo(t)
+				if err := os.Rename(repo, tmpPath("mine")); err != nil {
+					t.Fatalf("Failed to move test repo: %v", err)
+				}
+				if err := os.WriteFile(tmpPath("mine/notes.txt"), []byte("untracked"), 0644); err != nil {
+					t.Fatalf("Failed to write untracked file: %v", err)
+				}
+				if err := os.WriteFile(tmpPath("mine/test.txt"), []byte("changed"), 0644); err != nil {
+					t.Fatalf("Failed to change tracked file: %v", err)
+				}
+			},
+			opts: Options{
+				Path:  "mine",
+				Force: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if want, _ := filepath.EvalSymlinks(tmpPath("mine")); got.FinalPath != want && got.FinalPath != tmpPath("mine") {
+					t.Errorf("FinalPath = %s, want the repository %s", got.FinalPath, want)
+				}
+				if content, err := os.ReadFile(tmpPath("mine/notes.txt")); err != nil || string(content) != "untracked" {
+					t.Errorf("Untracked file lost: %q, %v", content, err)
+				}
+				if content, err := os.ReadFile(tmpPath("mine/test.txt")); err != nil || string(content) != "changed" {
+					t.Errorf("Uncommitted change lost: %q, %v", content, err)
+				}
+			},
+		},
+		{
+			name: "reject target inside the local repository",
+			opts: Options{
+				Path:      "mine",
+				TargetDir: "mine/copy",
+				Force:     true,
+			},
+			wantErr: ErrInvalidPath,
+			cleanup: func() error {
+				if _, err := os.Stat(tmpPath("mine/notes.txt")); err != nil {
+					return fmt.Errorf("untracked file lost: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			name: "reject sparse path outside repository",
+			opts: Options{
+				Path:   testRepo,
+				Sparse: []string{"docs", "../outside"},
+			},
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name: "template from repository",
+			opts: Options{
+				Path:      testRepo,
+				Template:  true,
+				TargetDir: "starter",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasCloned || got.FinalPath != tmpPath("starter") {
+					t.Errorf("Expected template copy in %v, got %+v", tmpPath("starter"), got)
+				}
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected template file: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(got.FinalPath, ".git")); !os.IsNotExist(err) {
+					t.Error("Expected git metadata to be removed")
+				}
+			},
+		},
+		{
+			name: "template at a ref",
+			opts: Options{
+				Path:      "file://" + filepath.ToSlash(taggedRepo),
+				Ref:       "v1",
+				Template:  true,
+				TargetDir: "starter-v1",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				content, err := os.ReadFile(filepath.Join(got.FinalPath, "test.txt"))
+				if err != nil || string(content) != "test" {
+					t.Errorf("Expected the template at v1, got %q: %v", content, err)
+				}
+			},
+		},
+		{
+			name: "refuse existing template target",
+			opts: Options{
+				Path:      testRepo,
+				Template:  true,
+				TargetDir: "starter",
+			},
+			wantErr: ErrTargetExists,
+		},
+		{
+			name: "template with new repository",
+			setup: func(t *testing.T) {
+				t.Setenv("GIT_AUTHOR_NAME", "Test User")
+				t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+				t.Setenv("GIT_COMMITTER_NAME", "Test User")
+				t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+			},
+			opts: Options{
+				Path:         testRepo,
+				Template:     true,
+				TemplateInit: true,
+				TargetDir:    "starter-init",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				cmd := exec.Command("git", "rev-list", "--count", "HEAD")
+				cmd.Dir = got.FinalPath
+				output, err := cmd.Output()
+				if err != nil || strings.TrimSpace(string(output)) != "1" {
+					t.Errorf("Expected a new repository with one commit, got %q: %v", output, err)
+				}
+			},
+		},
+		{
+			name: "force template from forge archive",
+			opts: Options{
+				Path:     ts.URL + "/user/starter",
+				Template: true,
+				Force:    true,
+				Forges:   []Forge{{Prefix: "test", Host: strings.TrimPrefix(ts.URL, "http://"), Type: "github"}},
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasDownloaded || got.FinalPath != tmpPath("starter") {
+					t.Errorf("Expected archive copy in %v, got %+v", tmpPath("starter"), got)
+				}
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected template file: %v", err)
+				}
+			},
+		},
+		{
+			name: "clone into target directory",
+			opts: Options{
+				Path:      testRepo,
+				TargetDir: "repo-review",
+				CloneRoot: tmpPath("src"),
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasCloned || got.FinalPath != tmpPath("repo-review") {
+					t.Errorf("Expected clone in %v, got %+v", tmpPath("repo-review"), got)
+				}
+			},
+		},
+		{
+			name: "reuse clone in target directory",
+			opts: Options{
+				Path:      testRepo,
+				TargetDir: "repo-review",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasReused {
+					t.Errorf("Expected clone in target directory to be reused, got %+v", got)
+				}
+			},
+		},
+		{
+			name: "reject target directory for local directory",
+			opts: Options{
+				Path:      "plain",
+				TargetDir: "other",
+			},
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name: "reuse clone under clone root",
+			setup: func(t *testing.T) {
+				repoDir := tmpPath("src/github.com/user/repo")
+				if err := exec.Command("git", "init", "-b", "main", repoDir).Run(); err != nil {
+					t.Fatalf("Failed to init repo: %v", err)
+				}
+				cmd := exec.Command("git", "-c", "user.name=Test User", "-c", "user.email=test@example.com",
+					"commit", "--allow-empty", "-m", "Initial commit")
+				cmd.Dir = repoDir
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("Failed to commit: %v", err)
+				}
+				cmd = exec.Command("git", "remote", "add", "origin", "git@github.com:user/repo.git")
+				cmd.Dir = repoDir
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("Failed to add remote: %v", err)
+				}
+			},
+			opts: Options{
+				Path:      "https://github.com/user/repo.git",
+				CloneRoot: tmpPath("src"),
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasReused {
+					t.Error("Expected clone under clone root to be reused")
+				}
+				if got.FinalPath != tmpPath("src/github.com/user/repo") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("src/github.com/user/repo"))
+				}
+			},
+		},
+		{
+			name: "expand forge shorthand",
+			opts: Options{
+				Path:      "gh:user/repo",
+				CloneRoot: tmpPath("src"),
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.FinalPath != tmpPath("src/github.com/user/repo") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("src/github.com/user/repo"))
+				}
+			},
+		},
+		{
+			// The forge is the test server, which has no refs to list, so
+			// the tree path is split without leaving the machine
+			name: "handle browser tree URL",
+			setup: func(t *testing.T) {
+				// Clone paths leave out the port
+				repoDir := tmpPath("src/127.0.0.1/user/repo")
+				if err := exec.Command("git", "init", "-b", "main", repoDir).Run(); err != nil {
+					t.Fatalf("Failed to init repo: %v", err)
+				}
+				cmd := exec.Command("git", "-c", "user.name=Test User", "-c", "user.email=test@example.com",
+					"commit", "--allow-empty", "-m", "Initial commit")
+				cmd.Dir = repoDir
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("Failed to commit: %v", err)
+				}
+				cmd = exec.Command("git", "remote", "add", "origin", ts.URL+"/user/repo.git")
+				cmd.Dir = repoDir
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("Failed to add remote: %v", err)
+				}
+				if err := os.MkdirAll(filepath.Join(repoDir, "docs"), 0755); err != nil {
+					t.Fatalf("Failed to create subdirectory: %v", err)
+				}
+			},
+			opts: Options{
+				Path:      ts.URL + "/user/repo/tree/main/docs",
+				Forges:    []Forge{{Prefix: "local", Host: tsHost, Type: git.ForgeGitHub}},
+				CloneRoot: tmpPath("src"),
+			},
+			checkResult: func(t *testing.T, got Result) {
+				want := tmpPath("src/127.0.0.1/user/repo/docs")
+				if got.FinalPath != want {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, want)
+				}
+			},
+		},
+		{
+			name: "refuse existing git target of another repository",
+			setup: func(t *testing.T) {
+				target := tmpPath(filepath.Base(testRepo))
+				if err := os.RemoveAll(target); err != nil {
+					t.Fatalf("Failed to remove clone: %v", err)
+				}
+				if err := os.MkdirAll(target, 0755); err != nil {
+					t.Fatalf("Failed to create target: %v", err)
+				}
+			},
+			opts: Options{
+				Path: testRepo,
+			},
+			wantErr: ErrTargetExists,
+		},
 		{
 			name: "handle tarball URL",
 			opts: Options{
@@ -336,8 +801,52 @@ func TestTake(t *testing.T) {
 				}
 			},
 		},
+		{
+			// Without a target the archive is staged at a relative path
+			name: "handle tarball with symlinks URL",
+			opts: Options{
+				Path: ts.URL + "/proj.tar.gz",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.FinalPath != tmpPath("proj") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("proj"))
+				}
+				content, err := os.ReadFile(filepath.Join(got.FinalPath, "README.link"))
+				if err != nil || string(content) != "readme" {
+					t.Errorf("Expected symlink to README, got %q: %v", content, err)
+				}
+			},
+		},
+		{
+			name: "template with symlinks from forge archive",
+			opts: Options{
+				Path:     ts.URL + "/user/linked",
+				Template: true,
+				Forges:   []Forge{{Prefix: "test", Host: strings.TrimPrefix(ts.URL, "http://"), Type: "github"}},
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasDownloaded || got.FinalPath != tmpPath("linked") {
+					t.Errorf("Expected archive copy in %v, got %+v", tmpPath("linked"), got)
+				}
+				if target, err := os.Readlink(filepath.Join(got.FinalPath, "README.link")); err != nil || target != "README" {
+					t.Errorf("Expected symlink to README, got %q: %v", target, err)
+				}
+			},
+		},
+		{
+			name: "refuse existing archive target",
+			opts: Options{
+				Path: ts.URL + "/test.zip",
+			},
+			wantErr: ErrTargetExists,
+		},
 		{
 			name: "handle zip URL",
+			setup: func(t *testing.T) {
+				if err := os.RemoveAll(tmpPath("testdir")); err != nil {
+					t.Fatalf("Failed to remove extracted directory: %v", err)
+				}
+			},
 			opts: Options{
 				Path: ts.URL + "/test.zip",
 			},
@@ -347,6 +856,18 @@ func TestTake(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "force replaces existing archive target",
+			opts: Options{
+				Path:  ts.URL + "/test.tar.gz",
+				Force: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected extracted file in target: %v", err)
+				}
+			},
+		},
 		{
 			name: "handle invalid URL",
 			opts: Options{
@@ -361,7 +882,315 @@ func TestTake(t *testing.T) {
 			},
 			wantErr: ErrDownloadFailed,
 		},
-	}
+		{
+			name: "handle archive entry limit",
+			opts: Options{
+				Path:              ts.URL + "/test.zip",
+				MaxExtractEntries: 1,
+			},
+			wantErr: ErrArchiveTooLarge,
+		},
+		{
+			name: "extract into target directory",
+			opts: Options{
+				Path:      ts.URL + "/test.tar.gz",
+				TargetDir: "vendor/lib",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.FinalPath != tmpPath("vendor/lib") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("vendor/lib"))
+				}
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected archive contents in target directory: %v", err)
+				}
+			},
+		},
+		{
+			name: "refuse existing archive target directory",
+			opts: Options{
+				Path:      ts.URL + "/test.zip",
+				TargetDir: "vendor/lib",
+			},
+			wantErr: ErrTargetExists,
+		},
+		{
+			name: "detect downloaded archive by content",
+			setup: func(t *testing.T) {
+				if err := os.RemoveAll(tmpPath("testdir")); err != nil {
+					t.Fatalf("Failed to remove extracted directory: %v", err)
+				}
+			},
+			opts: Options{
+				Path: ts.URL + "/download?id=42",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasDownloaded || got.FinalPath != tmpPath("testdir") {
+					t.Errorf("Expected archive to be extracted to %v, got %+v", tmpPath("testdir"), got)
+				}
+			},
+		},
+		{
+			name: "reject URL that is not an archive",
+			opts: Options{
+				Path: ts.URL + "/page",
+			},
+			wantErr: ErrUnsupportedArchive,
+		},
+		{
+			name: "archive type override",
+			opts: Options{
+				Path:        ts.URL + "/download?id=42",
+				ArchiveType: "zip",
+				TargetDir:   "forced",
+			},
+			wantErr: ErrExtractionFailed,
+		},
+		{
+			name: "decompress single file",
+			opts: Options{
+				Path: ts.URL + "/dump.sql.gz",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.FinalPath != tmpPath("dump.sql") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("dump.sql"))
+				}
+				content, err := os.ReadFile(filepath.Join(got.FinalPath, "dump.sql"))
+				if err != nil || string(content) != "CREATE TABLE test;\n" {
+					t.Errorf("Expected decompressed file, got %q: %v", content, err)
+				}
+			},
+		},
+		{
+			name: "extract local tarball",
+			setup: func(t *testing.T) {
+				if err := os.RemoveAll(tmpPath("testdir")); err != nil {
+					t.Fatalf("Failed to remove extracted directory: %v", err)
+				}
+			},
+			opts: Options{
+				Path: tarPath,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasExtracted || got.WasDownloaded {
+					t.Errorf("Expected local archive to be extracted, got %+v", got)
+				}
+				if got.FinalPath != tmpPath("testdir") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("testdir"))
+				}
+			},
+		},
+		{
+			name: "extract local archive by content",
+			setup: func(t *testing.T) {
+				content, err := os.ReadFile(zipPath)
+				if err != nil {
+					t.Fatalf("Failed to read zip: %v", err)
+				}
+				if err := os.WriteFile(tmpPath("release.tar.gz"), content, 0644); err != nil {
+					t.Fatalf("Failed to write archive: %v", err)
+				}
+			},
+			opts: Options{
+				Path:      "release.tar.gz",
+				TargetDir: "release",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if _, err := os.Stat(filepath.Join(got.FinalPath, "test.txt")); err != nil {
+					t.Errorf("Expected zip contents in target directory: %v", err)
+				}
+			},
+		},
+		{
+			name: "verify archive checksum",
+			opts: Options{
+				Path:      ts.URL + "/test.tar.gz",
+				TargetDir: "verified",
+				Checksum:  "sha256:" + tarSHA256,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasDownloaded || got.FinalPath != tmpPath("verified") {
+					t.Errorf("Expected verified archive in %v, got %+v", tmpPath("verified"), got)
+				}
+			},
+		},
+		{
+			name: "reject archive checksum mismatch",
+			opts: Options{
+				Path:      ts.URL + "/test.tar.gz",
+				TargetDir: "tampered",
+				Checksum:  "sha256:" + strings.Repeat("0", 64),
+			},
+			wantErr: ErrChecksumMismatch,
+			cleanup: func() error {
+				if _, err := os.Stat(tmpPath("tampered")); !os.IsNotExist(err) {
+					return errors.New("archive extracted despite the checksum mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name: "verify published checksum",
+			opts: Options{
+				Path:           ts.URL + "/test.tar.gz",
+				TargetDir:      "published",
+				VerifyChecksum: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.FinalPath != tmpPath("published") {
+					t.Errorf("FinalPath = %v, want %v", got.FinalPath, tmpPath("published"))
+				}
+			},
+		},
+		{
+			name: "reject archive without published checksum",
+			opts: Options{
+				Path:           ts.URL + "/test.zip",
+				TargetDir:      "unpublished",
+				VerifyChecksum: true,
+			},
+			wantErr: ErrChecksumNotFound,
+		},
+		{
+			name: "verify local archive checksum",
+			opts: Options{
+				Path:      tarPath,
+				TargetDir: "local-verified",
+				Checksum:  tarSHA256,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if !got.WasExtracted {
+					t.Errorf("Expected local archive to be extracted, got %+v", got)
+				}
+			},
+		},
+		{
+			name: "reject checksum for a directory",
+			opts: Options{
+				Path:     "checked",
+				Checksum: tarSHA256,
+			},
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name: "verify published signature",
+			opts: Options{
+				Path:            ts.URL + "/test.tar.gz",
+				TargetDir:       "signed",
+				TrustedKeys:     []string{string(trustedKey)},
+				VerifySignature: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.Signature == nil || got.Signature.Format != "minisign" {
+					t.Errorf("Expected a minisign signature, got %+v", got.Signature)
+				}
+			},
+		},
+		{
+			name: "verify signature at a URL",
+			opts: Options{
+				Path:        ts.URL + "/download",
+				TargetDir:   "signed-download",
+				TrustedKeys: []string{string(untrustedKey), string(trustedKey)},
+				Signature:   ts.URL + "/test.tar.gz.minisig",
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.Signature == nil || !got.WasDownloaded {
+					t.Errorf("Expected a verified download, got %+v", got)
+				}
+			},
+		},
+		{
+			name: "reject signature by an untrusted key",
+			opts: Options{
+				Path:            ts.URL + "/test.tar.gz",
+				TargetDir:       "untrusted",
+				TrustedKeys:     []string{string(untrustedKey)},
+				VerifySignature: true,
+			},
+			wantErr: ErrUntrustedKey,
+			cleanup: func() error {
+				if _, err := os.Stat(tmpPath("untrusted")); !os.IsNotExist(err) {
+					return errors.New("archive extracted despite the untrusted signature")
+				}
+				return nil
+			},
+		},
+		{
+			name: "reject archive without published signature",
+			opts: Options{
+				Path:            ts.URL + "/test.zip",
+				TargetDir:       "unsigned",
+				TrustedKeys:     []string{string(trustedKey)},
+				VerifySignature: true,
+			},
+			wantErr: ErrSignatureNotFound,
+		},
+		{
+			name: "reject signature of another file",
+			setup: func(t *testing.T) {
+				if err := os.WriteFile(tmpPath("other.minisig"), tarSignature, 0644); err != nil {
+					t.Fatalf("Failed to write signature: %v", err)
+				}
+			},
+			opts: Options{
+				Path:        ts.URL + "/test.zip",
+				TargetDir:   "forged",
+				TrustedKeys: []string{string(trustedKey)},
+				Signature:   "other.minisig",
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "verify local archive signature",
+			opts: Options{
+				Path:            tarPath,
+				TargetDir:       "local-signed",
+				TrustedKeys:     []string{string(trustedKey)},
+				VerifySignature: true,
+			},
+			checkResult: func(t *testing.T, got Result) {
+				if got.Signature == nil || !got.WasExtracted {
+					t.Errorf("Expected a verified local archive, got %+v", got)
+				}
+			},
+		},
+		{
+			name: "reject signature check without trusted keys",
+			opts: Options{
+				Path:            ts.URL + "/test.tar.gz",
+				VerifySignature: true,
+			},
+			wantErr: ErrInvalidTrustedKey,
+		},
+		{
+			name: "reject signature for a directory",
+			opts: Options{
+				Path:            "signed-dir",
+				TrustedKeys:     []string{string(trustedKey)},
+				VerifySignature: true,
+			},
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name: "reject local file that is not an archive",
+			setup: func(t *testing.T) {
+				if err := os.WriteFile(tmpPath("notes.txt"), []byte("notes"), 0644); err != nil {
+					t.Fatalf("Failed to write file: %v", err)
+				}
+			},
+			opts: Options{
+				Path: "notes.txt",
+			},
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name: "handle zip slip",
+			opts: Options{
+				Path: ts.URL + "/evil.zip",
+			},
+			wantErr: ErrUnsafeArchiveEntry,
+		},
+	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -378,7 +1207,7 @@ func TestTake(t *testing.T) {
 			}
 
 			if tt.wantErr != nil {
-				if got.Error != tt.wantErr {
+				if !errors.Is(got.Error, tt.wantErr) {
 					t.Errorf("Take() error = %v, wantErr %v", got.Error, tt.wantErr)
 				}
 				return
@@ -396,6 +1225,125 @@ func TestTake(t *testing.T) {
 	}
 }
 
+func TestTakeContext(t *testing.T) {
+	// The server sends the start of an archive, then stalls until the
+	// client gives up
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		w.Write(bytes.Repeat([]byte{0}, 1000))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		cancel  bool
+		timeout time.Duration
+		wantErr error
+	}{
+		{name: "timeout", timeout: 100 * time.Millisecond, wantErr: context.DeadlineExceeded},
+		{name: "canceled", cancel: true, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", filepath.Join(tmpDir, "tmp"))
+			if err := os.Mkdir(filepath.Join(tmpDir, "tmp"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if tt.cancel {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			got := TakeContext(ctx, Options{
+				Path:      ts.URL + "/stalled.tar.gz",
+				TargetDir: filepath.Join(tmpDir, "out", "stalled"),
+				CacheDir:  filepath.Join(tmpDir, "cache"),
+			})
+			if !errors.Is(got.Error, tt.wantErr) || !errors.Is(got.Error, ErrDownloadFailed) {
+				t.Errorf("TakeContext() error = %v, want %v wrapping %v", got.Error, ErrDownloadFailed, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("TakeContext() took %v to give up", elapsed)
+			}
+
+			// Nothing is left behind but the cache
+			for _, dir := range []string{"tmp", "out"} {
+				entries, _ := os.ReadDir(filepath.Join(tmpDir, dir))
+				if len(entries) > 0 {
+					t.Errorf("TakeContext() left %d entries in %s", len(entries), dir)
+				}
+			}
+		})
+	}
+
+	t.Run("already canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		target := filepath.Join(t.TempDir(), "dir")
+		if got := TakeContext(ctx, Options{Path: target}); !errors.Is(got.Error, context.Canceled) {
+			t.Errorf("TakeContext() error = %v, want %v", got.Error, context.Canceled)
+		}
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			t.Errorf("TakeContext() created %s", target)
+		}
+	})
+}
+
+func TestTemplateArchiveFallback(t *testing.T) {
+	// The forge answers the archive of a private repository with its
+	// sign-in page
+	cloned := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user/private/archive/HEAD.tar.gz":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Sign in</html>"))
+		case strings.HasPrefix(r.URL.Path, "/user/private.git/"):
+			cloned = true
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	opts := Options{
+		Path:      ts.URL + "/user/private",
+		Template:  true,
+		TargetDir: filepath.Join(dir, "starter"),
+		CacheDir:  filepath.Join(dir, "cache"),
+		Retry:     RetryPolicy{Attempts: 1},
+		Forges:    []Forge{{Prefix: "test", Host: strings.TrimPrefix(ts.URL, "http://"), Type: "github"}},
+	}
+	got := TakeContext(context.Background(), opts)
+	if got.Error == nil || errors.Is(got.Error, ErrDownloadFailed) || errors.Is(got.Error, ErrExtractionFailed) {
+		t.Errorf("TakeContext() error = %v, want the clone fallback to fail", got.Error)
+	}
+	if !cloned {
+		t.Error("Expected a clone after the archive download failed")
+	}
+
+	c, err := OpenCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Lookup(ts.URL + "/user/private/archive/HEAD.tar.gz"); err == nil {
+		t.Error("Expected the sign-in page to stay out of the cache")
+	}
+}
+
 func TestExpandPath(t *testing.T) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -443,3 +1391,104 @@ func TestExpandPath(t *testing.T) {
 		})
 	}
 }
+
+func TestSparsePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		subdir  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "no sparse paths",
+		},
+		{
+			name:   "subdir alone does not make a sparse checkout",
+			subdir: "docs",
+		},
+		{
+			name:  "cleaned and deduplicated",
+			paths: []string{"/apps/web/", "apps/./web", "libs"},
+			want:  []string{"apps/web", "libs"},
+		},
+		{
+			name:   "subdir added",
+			paths:  []string{"libs"},
+			subdir: "apps/web",
+			want:   []string{"libs", "apps/web"},
+		},
+		{
+			name:    "repository root",
+			paths:   []string{"."},
+			wantErr: true,
+		},
+		{
+			name:    "outside repository",
+			paths:   []string{"apps/../../etc"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sparsePaths(tt.paths, tt.subdir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sparsePaths() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sparsePaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		disposition string
+		want        string
+	}{
+		{
+			name: "URL path",
+			url:  "https://example.com/releases/v1.2.tar.gz?token=abc",
+			want: "v1.2.tar.gz",
+		},
+		{
+			name:        "Content-Disposition",
+			url:         "https://example.com/download?id=42",
+			disposition: `attachment; filename="project-1.0.zip"`,
+			want:        "project-1.0.zip",
+		},
+		{
+			name:        "extended filename",
+			url:         "https://example.com/download?id=42",
+			disposition: "attachment; filename*=UTF-8''caf%C3%A9.tar.gz",
+			want:        "café.tar.gz",
+		},
+		{
+			name:        "directories stripped",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="../../etc/evil.tar.gz"`,
+			want:        "evil.tar.gz",
+		},
+		{
+			name: "no path",
+			url:  "https://example.com",
+			want: "archive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.disposition != "" {
+				header.Set("Content-Disposition", tt.disposition)
+			}
+			if got := downloadName(tt.url, header); got != tt.want {
+				t.Errorf("downloadName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package take
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkTarget refuses an existing target unless force is set
+func checkTarget(target string, force bool) error {
+	if _, err := os.Lstat(target); err == nil && !force {
+		return fmt.Errorf("%w: %s", ErrTargetExists, target)
+	}
+	return nil
+}
+
+// localSource returns the directory of a repository URL that is a local
+// path or a file:// URL
+func localSource(repoURL string) (string, bool) {
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path), true
+	}
+	if strings.Contains(repoURL, "://") {
+		return "", false
+	}
+	path, err := expandPath(repoURL)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", false
+	}
+	return path, true
+}
+
+// overlap reports whether the directories a and b are the same, and
+// whether one contains the other, once made absolute and their symlinks
+// resolved
+func overlap(a, b string) (same, nested bool, err error) {
+	if a, err = resolveDir(a); err != nil {
+		return false, false, err
+	}
+	if b, err = resolveDir(b); err != nil {
+		return false, false, err
+	}
+	if a == b {
+		return true, false, nil
+	}
+	return false, within(a, b) || within(b, a), nil
+}
+
+// resolveDir returns the absolute path with symlinks resolved. Parts that
+// don't exist yet are kept as they are.
+func resolveDir(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// within reports whether path lies below root
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// stagingDir creates a hidden directory next to target where new content is
+// assembled before being moved into place. Staging on the same filesystem
+// keeps the final rename atomic.
+func stagingDir(target string) (string, error) {
+	parent := filepath.Dir(target)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		if os.IsPermission(err) {
+			return "", ErrPermissionDenied
+		}
+		return "", err
+	}
+	return os.MkdirTemp(parent, "."+filepath.Base(target)+".take-*")
+}
+
+// installDir moves the staged directory to target. An existing target is
+// only replaced when force is set: it is moved aside first, restored if the
+// staged directory can't be moved into place, and removed afterwards.
+func installDir(staged, target string, force bool) error {
+	if err := checkTarget(target, force); err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(target); err != nil {
+		if err := os.Rename(staged, target); err != nil {
+			return fmt.Errorf("failed to move directory: %v", err)
+		}
+		return nil
+	}
+
+	backup, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+".take-backup-*")
+	if err != nil {
+		return err
+	}
+	// Only the unique name is needed; the rename below recreates it
+	os.Remove(backup)
+
+	if err := os.Rename(target, backup); err != nil {
+		return fmt.Errorf("failed to back up existing target: %v", err)
+	}
+	if err := os.Rename(staged, target); err != nil {
+		os.Rename(backup, target)
+		return fmt.Errorf("failed to move directory: %v", err)
+	}
+
+	return os.RemoveAll(backup)
+}
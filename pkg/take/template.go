@@ -0,0 +1,141 @@
+package take
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/deblasis/take/internal/archive"
+	"github.com/deblasis/take/internal/git"
+)
+
+// handleTemplate copies a repository without its history into a new
+// directory, optionally starting a fresh repository there
+func handleTemplate(ctx context.Context, opts Options, loc git.Location) Result {
+	// Templates are new projects, so they ignore the clone root and are
+	// named after the directory they are copied from
+	name := opts.TargetDir
+	if name == "" {
+		name = git.GetRepoName(loc.URL)
+		if loc.Subdir != "" {
+			name = path.Base(loc.Subdir)
+		}
+	}
+	targetDir, err := expandPath(name)
+	if err != nil {
+		return Result{Error: err}
+	}
+
+	if err := checkTarget(targetDir, opts.Force); err != nil {
+		return Result{Error: err}
+	}
+
+	stagedDir, err := stagingDir(targetDir)
+	if err != nil {
+		return Result{Error: err}
+	}
+	defer os.RemoveAll(stagedDir)
+
+	contentsDir, downloaded, err := fetchTemplate(ctx, opts, loc, stagedDir)
+	if err != nil {
+		return Result{Error: err}
+	}
+
+	templateDir := filepath.Join(contentsDir, filepath.FromSlash(loc.Subdir))
+	if info, err := os.Stat(templateDir); err != nil || !info.IsDir() {
+		return Result{Error: fmt.Errorf("%w: %s not found in repository", ErrInvalidPath, loc.Subdir)}
+	}
+
+	if opts.TemplateInit {
+		if err := git.Init(ctx, templateDir); err != nil {
+			return Result{Error: fmt.Errorf("failed to initialize repository: %w", err)}
+		}
+	}
+
+	if err := installDir(templateDir, targetDir, opts.Force); err != nil {
+		return Result{Error: err}
+	}
+
+	absPath, err := filepath.Abs(targetDir)
+	if err != nil {
+		return Result{Error: err}
+	}
+
+	return Result{
+		FinalPath:     absPath,
+		WasCloned:     !downloaded,
+		WasDownloaded: downloaded,
+	}
+}
+
+// fetchTemplate places the files of the repository in a directory under
+// stagedDir and reports whether they came from the forge's archive. Private
+// repositories and hosts without archives fall back to a shallow clone,
+// which is stripped of its git metadata.
+func fetchTemplate(ctx context.Context, opts Options, loc git.Location, stagedDir string) (string, bool, error) {
+	// Archives don't include submodules
+	if archiveURL, ok := git.ArchiveURL(loc, forges(opts)); ok && !opts.Submodules {
+		contentsDir := filepath.Join(stagedDir, "archive")
+		err := fetchArchive(ctx, opts, archiveURL, archive.FormatTarGz, contentsDir)
+		if err == nil {
+			if rootDir, ok := archive.FindRoot(contentsDir); ok {
+				contentsDir = filepath.Join(contentsDir, rootDir)
+			}
+			return contentsDir, true, nil
+		}
+		// Archives that can't be extracted are retried as a clone too
+		if !(errors.Is(err, ErrDownloadFailed) || errors.Is(err, ErrExtractionFailed)) || ctx.Err() != nil {
+			return "", false, err
+		}
+		os.RemoveAll(contentsDir)
+	}
+	if opts.Offline {
+		return "", false, fmt.Errorf("%w: cloning %s needs the network", ErrOffline, loc.URL)
+	}
+
+	cloneOpts := git.CloneOptions{
+		URL:        loc.URL,
+		TargetDir:  filepath.Join(stagedDir, "clone"),
+		Depth:      1,
+		Ref:        loc.Ref,
+		Filter:     opts.CloneFilter,
+		Submodules: opts.Submodules,
+		Retry:      opts.Retry,
+		Known:      loc.Known,
+	}
+	if loc.Subdir != "" {
+		cloneOpts.Sparse = []string{loc.Subdir}
+	}
+	if err := git.Clone(ctx, cloneOpts); err != nil {
+		return "", false, fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	if err := removeGitDirs(cloneOpts.TargetDir); err != nil {
+		return "", false, err
+	}
+	return cloneOpts.TargetDir, false, nil
+}
+
+// removeGitDirs removes the git metadata of a repository and its
+// submodules, which keep a .git file instead of a directory
+func removeGitDirs(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() != ".git" {
+			return nil
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}